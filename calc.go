@@ -0,0 +1,428 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// ---- calculator: + - * / ^ % parentheses, functions, constants, variables ----
+
+type tokType int
+
+const (
+	tNumber tokType = iota
+	tOp
+	tLParen
+	tCallLParen // '(' that opens a function's argument list
+	tRParen
+	tFunc
+	tComma
+	tIdent
+)
+
+type token struct {
+	typ  tokType
+	val  string
+	argc int // populated on tFunc once its argument list has been closed
+}
+
+var constants = map[string]float64{
+	"pi": math.Pi,
+	"e":  math.E,
+}
+
+// funcArity maps a function name to its argument count, or -1 for variadic
+// (min, max — at least one argument).
+var funcArity = map[string]int{
+	"sin": 1, "cos": 1, "tan": 1,
+	"asin": 1, "acos": 1, "atan": 1,
+	"log": 1, "ln": 1, "sqrt": 1, "abs": 1, "exp": 1,
+	"floor": 1, "ceil": 1, "round": 1,
+	"pow": 2,
+	"min": -1, "max": -1,
+}
+
+var reAssign = regexp.MustCompile(`^([A-Za-z_][A-Za-z0-9_]*)\s*=\s*(.+)$`)
+
+// varsFromContext loads the per-user calculator variables persisted in a
+// UserState's generic string context (keys prefixed "var:").
+func varsFromContext(c map[string]string) map[string]float64 {
+	vars := map[string]float64{}
+	for k, v := range c {
+		name, ok := strings.CutPrefix(k, "var:")
+		if !ok {
+			continue
+		}
+		if f, err := strconv.ParseFloat(v, 64); err == nil {
+			vars[name] = f
+		}
+	}
+	return vars
+}
+
+// setVarInContext stores a single calculator variable into a UserState
+// context map in place, leaving any other keys untouched.
+func setVarInContext(c map[string]string, name string, value float64) {
+	c["var:"+name] = strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+// evalExprForUser evaluates expr against vars. A plain expression like
+// "2*(3+4)" returns its value with assigned == "". An assignment like
+// "x = 2*3" evaluates the right-hand side, returns it, and sets assigned to
+// "x" so the caller can persist vars[assigned] = value.
+func evalExprForUser(expr string, vars map[string]float64) (value float64, assigned string, err error) {
+	if m := reAssign.FindStringSubmatch(expr); m != nil {
+		name, rhs := strings.ToLower(m[1]), m[2]
+		if _, isConst := constants[name]; isConst {
+			return 0, "", fmt.Errorf("%q — зарезервированное имя", name)
+		}
+		if _, isFunc := funcArity[name]; isFunc {
+			return 0, "", fmt.Errorf("%q — зарезервированное имя", name)
+		}
+		val, err := evalExpr(rhs, vars)
+		if err != nil {
+			return 0, "", err
+		}
+		return val, name, nil
+	}
+	val, err := evalExpr(expr, vars)
+	return val, "", err
+}
+
+func evalExpr(s string, vars map[string]float64) (float64, error) {
+	toks, err := tokenize(s)
+	if err != nil {
+		return 0, err
+	}
+	rpn, err := shuntingYard(toks)
+	if err != nil {
+		return 0, err
+	}
+	return evalRPN(rpn, vars)
+}
+
+func tokenize(s string) ([]token, error) {
+	s = strings.ReplaceAll(s, " ", "")
+	if s == "" {
+		return nil, errors.New("empty expression")
+	}
+	var out []token
+	i := 0
+	for i < len(s) {
+		c := s[i]
+		switch {
+		case (c >= '0' && c <= '9') || c == '.':
+			j := i + 1
+			for j < len(s) && ((s[j] >= '0' && s[j] <= '9') || s[j] == '.') {
+				j++
+			}
+			out = append(out, token{typ: tNumber, val: s[i:j]})
+			i = j
+		case isIdentStart(c):
+			j := i + 1
+			for j < len(s) && isIdentPart(s[j]) {
+				j++
+			}
+			name := strings.ToLower(s[i:j])
+			if j < len(s) && s[j] == '(' {
+				if _, ok := funcArity[name]; !ok {
+					return nil, fmt.Errorf("unknown function: %s", name)
+				}
+				out = append(out, token{typ: tFunc, val: name})
+				out = append(out, token{typ: tCallLParen, val: "("})
+				j++
+			} else if f, ok := constants[name]; ok {
+				out = append(out, token{typ: tNumber, val: strconv.FormatFloat(f, 'g', -1, 64)})
+			} else {
+				out = append(out, token{typ: tIdent, val: name})
+			}
+			i = j
+		case c == '+' || c == '-' || c == '*' || c == '/' || c == '^' || c == '%':
+			out = append(out, token{typ: tOp, val: string(c)})
+			i++
+		case c == '(':
+			out = append(out, token{typ: tLParen, val: "("})
+			i++
+		case c == ')':
+			out = append(out, token{typ: tRParen, val: ")"})
+			i++
+		case c == ',':
+			out = append(out, token{typ: tComma, val: ","})
+			i++
+		default:
+			return nil, fmt.Errorf("bad char: %q", c)
+		}
+	}
+	// Handle unary minus by rewriting: (-x) or at start -> (0-x)
+	out = rewriteUnaryMinus(out)
+	return out, nil
+}
+
+func isIdentStart(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || c == '_'
+}
+
+func isIdentPart(c byte) bool {
+	return isIdentStart(c) || (c >= '0' && c <= '9')
+}
+
+func rewriteUnaryMinus(toks []token) []token {
+	var out []token
+	for i := 0; i < len(toks); i++ {
+		t := toks[i]
+		if t.typ == tOp && t.val == "-" {
+			if i == 0 || isPrefixContext(toks[i-1]) {
+				// unary minus -> 0 - ...
+				out = append(out, token{typ: tNumber, val: "0"})
+			}
+		}
+		out = append(out, t)
+	}
+	return out
+}
+
+// isPrefixContext reports whether a '-' following prev must be unary
+// (there's no value to its left yet).
+func isPrefixContext(prev token) bool {
+	switch prev.typ {
+	case tOp, tLParen, tCallLParen, tComma:
+		return true
+	default:
+		return false
+	}
+}
+
+func prec(op string) int {
+	switch op {
+	case "+", "-":
+		return 1
+	case "*", "/", "%":
+		return 2
+	case "^":
+		return 3
+	default:
+		return 0
+	}
+}
+
+func rightAssoc(op string) bool {
+	return op == "^"
+}
+
+func shuntingYard(toks []token) ([]token, error) {
+	var out []token
+	var stack []token
+	var argCounts []int
+	for _, t := range toks {
+		switch t.typ {
+		case tNumber, tIdent:
+			out = append(out, t)
+		case tFunc:
+			stack = append(stack, t)
+			argCounts = append(argCounts, 1)
+		case tComma:
+			for len(stack) > 0 && stack[len(stack)-1].typ != tCallLParen {
+				out = append(out, stack[len(stack)-1])
+				stack = stack[:len(stack)-1]
+			}
+			if len(stack) == 0 {
+				return nil, errors.New("comma outside function call")
+			}
+			argCounts[len(argCounts)-1]++
+		case tOp:
+			for len(stack) > 0 {
+				top := stack[len(stack)-1]
+				if top.typ != tOp {
+					break
+				}
+				if prec(top.val) > prec(t.val) || (prec(top.val) == prec(t.val) && !rightAssoc(t.val)) {
+					out = append(out, top)
+					stack = stack[:len(stack)-1]
+				} else {
+					break
+				}
+			}
+			stack = append(stack, t)
+		case tLParen, tCallLParen:
+			stack = append(stack, t)
+		case tRParen:
+			found := false
+			for len(stack) > 0 {
+				top := stack[len(stack)-1]
+				stack = stack[:len(stack)-1]
+				if top.typ == tLParen || top.typ == tCallLParen {
+					found = true
+					if top.typ == tCallLParen {
+						if len(stack) == 0 || stack[len(stack)-1].typ != tFunc {
+							return nil, errors.New("malformed function call")
+						}
+						fn := stack[len(stack)-1]
+						stack = stack[:len(stack)-1]
+						fn.argc = argCounts[len(argCounts)-1]
+						argCounts = argCounts[:len(argCounts)-1]
+						out = append(out, fn)
+					}
+					break
+				}
+				out = append(out, top)
+			}
+			if !found {
+				return nil, errors.New("mismatched parentheses")
+			}
+		}
+	}
+	for len(stack) > 0 {
+		top := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if top.typ == tLParen || top.typ == tCallLParen || top.typ == tRParen {
+			return nil, errors.New("mismatched parentheses")
+		}
+		out = append(out, top)
+	}
+	return out, nil
+}
+
+func evalRPN(toks []token, vars map[string]float64) (float64, error) {
+	var st []float64
+	for _, t := range toks {
+		switch t.typ {
+		case tNumber:
+			v, err := strconv.ParseFloat(t.val, 64)
+			if err != nil {
+				return 0, errors.New("bad number")
+			}
+			st = append(st, v)
+		case tIdent:
+			v, ok := vars[t.val]
+			if !ok {
+				return 0, fmt.Errorf("unknown variable: %s", t.val)
+			}
+			st = append(st, v)
+		case tOp:
+			if len(st) < 2 {
+				return 0, errors.New("bad expression")
+			}
+			b := st[len(st)-1]
+			a := st[len(st)-2]
+			st = st[:len(st)-2]
+			r, err := applyOp(t.val, a, b)
+			if err != nil {
+				return 0, err
+			}
+			st = append(st, r)
+		case tFunc:
+			r, err := applyFunc(t.val, t.argc, st)
+			if err != nil {
+				return 0, err
+			}
+			st = st[:len(st)-t.argc]
+			st = append(st, r)
+		}
+	}
+	if len(st) != 1 {
+		return 0, errors.New("bad expression")
+	}
+	if math.IsInf(st[0], 0) || math.IsNaN(st[0]) {
+		return 0, errors.New("bad result")
+	}
+	return st[0], nil
+}
+
+func applyOp(op string, a, b float64) (float64, error) {
+	switch op {
+	case "+":
+		return a + b, nil
+	case "-":
+		return a - b, nil
+	case "*":
+		return a * b, nil
+	case "/":
+		if b == 0 {
+			return 0, errors.New("division by zero")
+		}
+		return a / b, nil
+	case "%":
+		if b == 0 {
+			return 0, errors.New("division by zero")
+		}
+		return math.Mod(a, b), nil
+	case "^":
+		return math.Pow(a, b), nil
+	default:
+		return 0, fmt.Errorf("unknown operator: %s", op)
+	}
+}
+
+func applyFunc(name string, argc int, st []float64) (float64, error) {
+	expected := funcArity[name]
+	if expected >= 0 && argc != expected {
+		return 0, fmt.Errorf("%s expects %d arg(s), got %d", name, expected, argc)
+	}
+	if expected < 0 && argc < 1 {
+		return 0, fmt.Errorf("%s expects at least 1 arg", name)
+	}
+	if len(st) < argc {
+		return 0, errors.New("bad expression")
+	}
+	args := st[len(st)-argc:]
+
+	switch name {
+	case "sin":
+		return math.Sin(args[0]), nil
+	case "cos":
+		return math.Cos(args[0]), nil
+	case "tan":
+		return math.Tan(args[0]), nil
+	case "asin":
+		return math.Asin(args[0]), nil
+	case "acos":
+		return math.Acos(args[0]), nil
+	case "atan":
+		return math.Atan(args[0]), nil
+	case "log":
+		return math.Log10(args[0]), nil
+	case "ln":
+		return math.Log(args[0]), nil
+	case "sqrt":
+		if args[0] < 0 {
+			return 0, errors.New("sqrt of negative number")
+		}
+		return math.Sqrt(args[0]), nil
+	case "abs":
+		return math.Abs(args[0]), nil
+	case "exp":
+		return math.Exp(args[0]), nil
+	case "floor":
+		return math.Floor(args[0]), nil
+	case "ceil":
+		return math.Ceil(args[0]), nil
+	case "round":
+		return math.Round(args[0]), nil
+	case "pow":
+		return math.Pow(args[0], args[1]), nil
+	case "min":
+		m := args[0]
+		for _, v := range args[1:] {
+			m = math.Min(m, v)
+		}
+		return m, nil
+	case "max":
+		m := args[0]
+		for _, v := range args[1:] {
+			m = math.Max(m, v)
+		}
+		return m, nil
+	default:
+		return 0, fmt.Errorf("unknown function: %s", name)
+	}
+}
+
+func trimFloat(v float64) string {
+	// Pretty format: remove trailing zeros
+	s := strconv.FormatFloat(v, 'f', -1, 64)
+	return s
+}
@@ -7,11 +7,9 @@ import (
 	"crypto/rand"
 	"database/sql"
 	"encoding/base64"
-	"encoding/json"
 	"errors"
 	"fmt"
 	"log"
-	"math"
 	"os"
 	"sort"
 	"strconv"
@@ -31,6 +29,8 @@ const (
 	btnCreate = "➕ Создать"
 	btnCancel = "🫩 Отмена"
 	btnRead   = "📚 Прочитать"
+	btnSearch = "🔎 Поиск"
+	btnTrash  = "🗑 Корзина"
 )
 
 type Mode int
@@ -39,22 +39,22 @@ const (
 	ModeNone Mode = iota
 	ModeCalcAwaitExpr
 	ModeNoteAwaitContent
+	ModeNoteAwaitSearch
+	ModePassAwaitLabel
 )
 
-type UserState struct {
-	Mode Mode
-}
-
-type NotePayload struct {
-	Kind    string `json:"kind"`              // "text" | "photo" | "voice"
-	Text    string `json:"text,omitempty"`    // for text
-	FileID  string `json:"file_id,omitempty"` // for photo/voice
-	Caption string `json:"caption,omitempty"` // optional
-}
-
-type NoteRow struct {
-	ID        int64
-	CreatedAt time.Time
+// App bundles the bot's shared dependencies. Handlers are methods on it so
+// adding a new cross-cutting concern (rate limiting, quotas, ...) doesn't
+// mean growing every handler's parameter list again.
+type App struct {
+	bot         *tgbotapi.BotAPI
+	db          *sql.DB
+	key         []byte
+	store       StateStore
+	limiter     RateLimiter
+	noteLimits  userLimits
+	transcriber Transcriber // nil if TRANSCRIBE_ENABLED is not set
+	kb          tgbotapi.ReplyKeyboardMarkup
 }
 
 func main() {
@@ -65,6 +65,7 @@ func main() {
 	token := mustEnv("BOT_TOKEN")
 	keyB64 := mustEnv("ENC_KEY_B64")
 	dbPath := os.Getenv("DB_PATH")
+	stateTTL := time.Duration(mustEnvIntDefault("STATE_TTL_MINUTES", 15)) * time.Minute
 
 	key, err := base64.StdEncoding.DecodeString(keyB64)
 	if err != nil || len(key) != 32 {
@@ -82,6 +83,11 @@ func main() {
 		log.Fatal(err)
 	}
 
+	if err := runMigrations(context.Background(), db); err != nil {
+		log.Fatalf("migrations: %v", err)
+	}
+	store := NewSQLiteStateStore(db)
+
 	log.Printf("Bot authorized as @%s", bot.Self.UserName)
 
 	// Reply keyboard: always visible bottom buttons
@@ -94,27 +100,56 @@ func main() {
 	)
 	mainKeyboard.ResizeKeyboard = true
 
-	states := map[int64]*UserState{} // userID -> state
-	_ = states
+	sweepCtx, stopSweeper := context.WithCancel(context.Background())
+	defer stopSweeper()
+	go runStateSweeper(sweepCtx, store, stateTTL)
+
+	app := &App{
+		bot:     bot,
+		db:      db,
+		key:     key,
+		store:   store,
+		limiter: NewTokenBucketLimiter(realClock{}, actionLimits),
+		noteLimits: userLimits{
+			MaxNotes:      mustEnvIntDefault("NOTES_MAX_COUNT", 500),
+			MaxTotalBytes: int64(mustEnvIntDefault("NOTES_MAX_TOTAL_BYTES", 50*1024*1024)),
+		},
+		transcriber: newTranscriberFromEnv(),
+		kb:          mainKeyboard,
+	}
+
 	u := tgbotapi.NewUpdate(0)
 	u.Timeout = 60
 
 	updates := bot.GetUpdatesChan(u)
 	for upd := range updates {
 		if upd.Message != nil {
-			handleMessage(context.Background(), bot, db, key, states, upd.Message, mainKeyboard)
+			app.handleMessage(context.Background(), upd.Message)
 		} else if upd.CallbackQuery != nil {
-			handleCallback(context.Background(), bot, db, key, upd.CallbackQuery, mainKeyboard)
+			app.handleCallback(context.Background(), upd.CallbackQuery)
 		}
 	}
 }
 
-func handleMessage(ctx context.Context, bot *tgbotapi.BotAPI, db *sql.DB, key []byte, states map[int64]*UserState, m *tgbotapi.Message, kb tgbotapi.ReplyKeyboardMarkup) {
+// checkRateLimit answers the limiter for userID/action and, if the action is
+// denied, sends the localized retry-after reply itself. Callers should
+// return immediately when it reports denied.
+func (a *App) checkRateLimit(chatID, userID int64, action string) (denied bool) {
+	ok, retryAfter := a.limiter.Allow(userID, action)
+	if ok {
+		return false
+	}
+	_, _ = a.bot.Send(tgbotapi.NewMessage(chatID, rateLimitMessage(retryAfter)))
+	return true
+}
+
+func (a *App) handleMessage(ctx context.Context, m *tgbotapi.Message) {
+	bot, db, key, store, kb := a.bot, a.db, a.key, a.store, a.kb
 	userID := m.From.ID
-	st := states[userID]
-	if st == nil {
+	st, err := store.Get(ctx, userID)
+	if err != nil {
+		log.Printf("state get: %v", err)
 		st = &UserState{Mode: ModeNone}
-		states[userID] = st
 	}
 
 	// /start
@@ -123,6 +158,7 @@ func handleMessage(ctx context.Context, bot *tgbotapi.BotAPI, db *sql.DB, key []
 		msg.ReplyMarkup = kb
 		_, _ = bot.Send(msg)
 		st.Mode = ModeNone
+		_ = store.Set(ctx, userID, st)
 		return
 	}
 
@@ -130,18 +166,24 @@ func handleMessage(ctx context.Context, bot *tgbotapi.BotAPI, db *sql.DB, key []
 	switch strings.TrimSpace(m.Text) {
 	case btnCalc:
 		st.Mode = ModeCalcAwaitExpr
-		msg := tgbotapi.NewMessage(m.Chat.ID, "Введи выражение (например: 2*(3+4)/5).")
+		_ = store.Set(ctx, userID, st)
+		msg := tgbotapi.NewMessage(m.Chat.ID, "Введи выражение (например: 2*(3+4)/5, sqrt(2)^2, pow(2,10) или x = 3*7).")
 		msg.ReplyMarkup = kb
 		_, _ = bot.Send(msg)
 		return
 
 	case btnPass:
-		pass := genPassword8()
-		msg := tgbotapi.NewMessage(m.Chat.ID, "Твой пароль: `"+pass+"`")
-		msg.ParseMode = "Markdown"
-		msg.ReplyMarkup = kb
-		_, _ = bot.Send(msg)
 		st.Mode = ModeNone
+		if st.Context == nil {
+			st.Context = map[string]string{}
+		}
+		clearPassContext(st.Context)
+		cfg := defaultPassConfig()
+		cfg.saveToContext(st.Context)
+		_ = store.Set(ctx, userID, st)
+		msg := tgbotapi.NewMessage(m.Chat.ID, "Настрой генератор паролей:")
+		msg.ReplyMarkup = passConfigKeyboard(cfg)
+		_, _ = bot.Send(msg)
 		return
 
 	case btnNotes:
@@ -150,6 +192,10 @@ func handleMessage(ctx context.Context, bot *tgbotapi.BotAPI, db *sql.DB, key []
 			tgbotapi.NewInlineKeyboardRow(
 				tgbotapi.NewInlineKeyboardButtonData(btnCreate, "notes:create"),
 				tgbotapi.NewInlineKeyboardButtonData(btnRead, "notes:read"),
+				tgbotapi.NewInlineKeyboardButtonData(btnSearch, "notes:search"),
+			),
+			tgbotapi.NewInlineKeyboardRow(
+				tgbotapi.NewInlineKeyboardButtonData(btnTrash, "notes:trash"),
 				tgbotapi.NewInlineKeyboardButtonData(btnCancel, "notes:cancel"),
 			),
 		)
@@ -157,44 +203,128 @@ func handleMessage(ctx context.Context, bot *tgbotapi.BotAPI, db *sql.DB, key []
 		msg.ReplyMarkup = inline
 		_, _ = bot.Send(msg)
 		st.Mode = ModeNone
+		_ = store.Set(ctx, userID, st)
 		return
 	}
 
 	// Mode-specific behavior
 	switch st.Mode {
 	case ModeCalcAwaitExpr:
+		if a.checkRateLimit(m.Chat.ID, userID, actionCalc) {
+			return
+		}
 		expr := strings.TrimSpace(m.Text)
 		if expr == "" {
 			_, _ = bot.Send(tgbotapi.NewMessage(m.Chat.ID, "Пусто. Введи выражение текстом."))
 			return
 		}
-		val, err := evalExpr(expr)
+		vars := varsFromContext(st.Context)
+		val, assigned, err := evalExprForUser(expr, vars)
 		if err != nil {
 			_, _ = bot.Send(tgbotapi.NewMessage(m.Chat.ID, "Ошибка: "+err.Error()))
 			return
 		}
-		out := fmt.Sprintf("= %v", trimFloat(val))
+		var out string
+		if assigned != "" {
+			if st.Context == nil {
+				st.Context = map[string]string{}
+			}
+			setVarInContext(st.Context, assigned, val)
+			out = fmt.Sprintf("%s = %v", assigned, trimFloat(val))
+		} else {
+			out = fmt.Sprintf("= %v", trimFloat(val))
+		}
 		msg := tgbotapi.NewMessage(m.Chat.ID, out)
 		msg.ReplyMarkup = kb
 		_, _ = bot.Send(msg)
 		st.Mode = ModeNone
+		_ = store.Set(ctx, userID, st)
 		return
 
 	case ModeNoteAwaitContent:
+		if a.checkRateLimit(m.Chat.ID, userID, actionNoteCreate) {
+			return
+		}
 		// Accept: text OR photo OR voice
 		payload, err := messageToPayload(m)
 		if err != nil {
 			_, _ = bot.Send(tgbotapi.NewMessage(m.Chat.ID, "Пришли текст, фото или голосовое."))
 			return
 		}
-		if err := saveNote(ctx, db, key, userID, payload); err != nil {
+
+		editIDStr := st.Context["edit_note_id"]
+		st.Mode = ModeNone
+		delete(st.Context, "edit_note_id")
+		_ = store.Set(ctx, userID, st)
+
+		if editIDStr != "" {
+			editID, _ := strconv.ParseInt(editIDStr, 10, 64)
+			if err := a.editNoteWithQuota(ctx, userID, editID, payload); err != nil {
+				_, _ = bot.Send(tgbotapi.NewMessage(m.Chat.ID, "Не смог сохранить: "+err.Error()))
+				return
+			}
+			if payload.Kind == "voice" {
+				a.transcribeVoiceAsync(m.Chat.ID, userID, editID)
+			}
+			msg := tgbotapi.NewMessage(m.Chat.ID, "✅ Заметка обновлена.")
+			msg.ReplyMarkup = kb
+			_, _ = bot.Send(msg)
+			return
+		}
+
+		noteID, err := a.saveNoteWithQuota(ctx, userID, payload)
+		if err != nil {
 			_, _ = bot.Send(tgbotapi.NewMessage(m.Chat.ID, "Не смог сохранить: "+err.Error()))
 			return
 		}
+		if payload.Kind == "voice" {
+			a.transcribeVoiceAsync(m.Chat.ID, userID, noteID)
+		}
 		msg := tgbotapi.NewMessage(m.Chat.ID, "✅ Сохранено. Хочешь ещё — снова нажми «Заметки».")
 		msg.ReplyMarkup = kb
 		_, _ = bot.Send(msg)
+		return
+
+	case ModeNoteAwaitSearch:
+		query := strings.TrimSpace(m.Text)
+		st.Mode = ModeNone
+		_ = store.Set(ctx, userID, st)
+		if query == "" {
+			_, _ = bot.Send(tgbotapi.NewMessage(m.Chat.ID, "Пусто. Введи слово, #tag или @mention."))
+			return
+		}
+		rows, err := searchNotes(ctx, db, key, userID, query)
+		if err != nil {
+			_, _ = bot.Send(tgbotapi.NewMessage(m.Chat.ID, "Не смог найти: "+err.Error()))
+			return
+		}
+		if len(rows) == 0 {
+			_, _ = bot.Send(tgbotapi.NewMessage(m.Chat.ID, "Ничего не нашлось по запросу «"+query+"»."))
+			return
+		}
+		msg := tgbotapi.NewMessage(m.Chat.ID, "Нашлось по «"+query+"»:")
+		msg.ReplyMarkup = notesInlineList(rows)
+		_, _ = bot.Send(msg)
+		return
+
+	case ModePassAwaitLabel:
+		label := strings.TrimSpace(m.Text)
+		encSecret := st.Context["pass:last_enc"]
 		st.Mode = ModeNone
+		clearPassContext(st.Context)
+		_ = store.Set(ctx, userID, st)
+		secret, err := decryptSecretFromContext(key, encSecret)
+		if err != nil {
+			_, _ = bot.Send(tgbotapi.NewMessage(m.Chat.ID, "Не смог сохранить: "+err.Error()))
+			return
+		}
+		if _, err := a.saveNoteWithQuota(ctx, userID, NotePayload{Kind: "password", Text: secret, Caption: label}); err != nil {
+			_, _ = bot.Send(tgbotapi.NewMessage(m.Chat.ID, "Не смог сохранить: "+err.Error()))
+			return
+		}
+		msg := tgbotapi.NewMessage(m.Chat.ID, "✅ Пароль сохранён в заметках.")
+		msg.ReplyMarkup = kb
+		_, _ = bot.Send(msg)
 		return
 	default:
 		// fallback
@@ -204,7 +334,8 @@ func handleMessage(ctx context.Context, bot *tgbotapi.BotAPI, db *sql.DB, key []
 	}
 }
 
-func handleCallback(ctx context.Context, bot *tgbotapi.BotAPI, db *sql.DB, key []byte, cq *tgbotapi.CallbackQuery, kb tgbotapi.ReplyKeyboardMarkup) {
+func (a *App) handleCallback(ctx context.Context, cq *tgbotapi.CallbackQuery) {
+	bot, db, key, store, kb := a.bot, a.db, a.key, a.store, a.kb
 	// Always answer callback to remove "loading"
 	_, _ = bot.Request(tgbotapi.NewCallback(cq.ID, ""))
 
@@ -214,19 +345,20 @@ func handleCallback(ctx context.Context, bot *tgbotapi.BotAPI, db *sql.DB, key [
 
 	switch data {
 	case "notes:create":
-		// We need to set state, but callback handler doesn't have states map.
-		// Easiest MVP: instruct user to type "/note" and handle in messages.
-		// But user wants simple; we'll do it via a global trick:
-		// We'll store mode in DB? Too heavy. So we use a hack: send message telling to send note and rely on /note command.
-		// Better: keep state in memory globally, but callback doesn't have access here.
-		// We'll implement a minimal workaround: prompt with special command.
-		msg := tgbotapi.NewMessage(chatID, "Напиши команду /create_note, затем пришли текст/фото/голосовое (следующим сообщением).")
+		if err := store.Set(ctx, userID, &UserState{Mode: ModeNoteAwaitContent}); err != nil {
+			_, _ = bot.Send(tgbotapi.NewMessage(chatID, "Не смог начать создание заметки: "+err.Error()))
+			return
+		}
+		msg := tgbotapi.NewMessage(chatID, "Пришли текст, фото или голосовое — сохраню как заметку.")
 		msg.ReplyMarkup = kb
 		_, _ = bot.Send(msg)
 		return
 	case "notes:cancel":
 		return
 	case "notes:read":
+		if a.checkRateLimit(chatID, userID, actionNoteRead) {
+			return
+		}
 		rows, err := listNotes(ctx, db, userID, 20)
 		if err != nil {
 			_, _ = bot.Send(tgbotapi.NewMessage(chatID, "Не смог прочитать список: "+err.Error()))
@@ -237,24 +369,135 @@ func handleCallback(ctx context.Context, bot *tgbotapi.BotAPI, db *sql.DB, key [
 			return
 		}
 
-		// Inline keyboard as "hyperlinks"
 		// show newest first
 		sort.Slice(rows, func(i, j int) bool { return rows[i].CreatedAt.After(rows[j].CreatedAt) })
 
-		var buttons [][]tgbotapi.InlineKeyboardButton
-		for _, r := range rows {
-			title := r.CreatedAt.Format("2006-01-02 15:04:05")
-			btn := tgbotapi.NewInlineKeyboardButtonData("🗒 "+title, fmt.Sprintf("note:%d", r.ID))
-			buttons = append(buttons, tgbotapi.NewInlineKeyboardRow(btn))
+		msg := tgbotapi.NewMessage(chatID, "Твои заметки (последние 20):")
+		msg.ReplyMarkup = notesInlineList(rows)
+		_, _ = bot.Send(msg)
+		return
+	case "notes:search":
+		if err := store.Set(ctx, userID, &UserState{Mode: ModeNoteAwaitSearch}); err != nil {
+			_, _ = bot.Send(tgbotapi.NewMessage(chatID, "Не смог начать поиск: "+err.Error()))
+			return
+		}
+		msg := tgbotapi.NewMessage(chatID, "Введи слово, #tag или @mention для поиска по заметкам.")
+		msg.ReplyMarkup = kb
+		_, _ = bot.Send(msg)
+		return
+	case "notes:trash":
+		rows, err := listDeletedNotes(ctx, db, userID, 20)
+		if err != nil {
+			_, _ = bot.Send(tgbotapi.NewMessage(chatID, "Не смог прочитать корзину: "+err.Error()))
+			return
+		}
+		if len(rows) == 0 {
+			_, _ = bot.Send(tgbotapi.NewMessage(chatID, "Корзина пуста."))
+			return
+		}
+		msg := tgbotapi.NewMessage(chatID, "Корзина (последние 20):")
+		msg.ReplyMarkup = trashInlineList(rows)
+		_, _ = bot.Send(msg)
+		return
+	case "pass:cancel":
+		st, err := store.Get(ctx, userID)
+		if err == nil {
+			clearPassContext(st.Context)
+			st.Mode = ModeNone
+			_ = store.Set(ctx, userID, st)
+		}
+		return
+	case "pass:generate":
+		if a.checkRateLimit(chatID, userID, actionPassGen) {
+			return
 		}
+		st, err := store.Get(ctx, userID)
+		if err != nil {
+			_, _ = bot.Send(tgbotapi.NewMessage(chatID, "Не смог прочитать настройки: "+err.Error()))
+			return
+		}
+		cfg := passConfigFromContext(st.Context)
+		secret, bits, err := generatePassword(cfg)
+		if err != nil {
+			_, _ = bot.Send(tgbotapi.NewMessage(chatID, "Ошибка: "+err.Error()))
+			return
+		}
+		encSecret, err := encryptSecretForContext(key, secret)
+		if err != nil {
+			_, _ = bot.Send(tgbotapi.NewMessage(chatID, "Ошибка: "+err.Error()))
+			return
+		}
+		if st.Context == nil {
+			st.Context = map[string]string{}
+		}
+		st.Context["pass:last_enc"] = encSecret
+		_ = store.Set(ctx, userID, st)
 
-		inline := tgbotapi.NewInlineKeyboardMarkup(buttons...)
-		msg := tgbotapi.NewMessage(chatID, "Твои заметки (последние 20):")
-		msg.ReplyMarkup = inline
+		text := fmt.Sprintf("`%s`\n\nЭнтропия: %.0f бит (%s)", secret, bits, entropyLabel(bits))
+		msg := tgbotapi.NewMessage(chatID, text)
+		msg.ParseMode = "Markdown"
+		msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(
+			tgbotapi.NewInlineKeyboardRow(
+				tgbotapi.NewInlineKeyboardButtonData("💾 Сохранить", "pass:save"),
+				tgbotapi.NewInlineKeyboardButtonData("🆕 Ещё", "pass:generate"),
+			),
+		)
+		_, _ = bot.Send(msg)
+		return
+	case "pass:save":
+		st, err := store.Get(ctx, userID)
+		if err != nil {
+			_, _ = bot.Send(tgbotapi.NewMessage(chatID, "Не смог начать сохранение: "+err.Error()))
+			return
+		}
+		st.Mode = ModePassAwaitLabel
+		if err := store.Set(ctx, userID, st); err != nil {
+			_, _ = bot.Send(tgbotapi.NewMessage(chatID, "Не смог начать сохранение: "+err.Error()))
+			return
+		}
+		msg := tgbotapi.NewMessage(chatID, "Как назвать эту запись? Пришли название текстом.")
+		msg.ReplyMarkup = kb
 		_, _ = bot.Send(msg)
 		return
 	default:
-		if strings.HasPrefix(data, "note:") {
+		switch {
+		case strings.HasPrefix(data, "note:edit:"):
+			idStr := strings.TrimPrefix(data, "note:edit:")
+			if err := store.Set(ctx, userID, &UserState{Mode: ModeNoteAwaitContent, Context: map[string]string{"edit_note_id": idStr}}); err != nil {
+				_, _ = bot.Send(tgbotapi.NewMessage(chatID, "Не смог начать редактирование: "+err.Error()))
+				return
+			}
+			msg := tgbotapi.NewMessage(chatID, "Пришли новый текст, фото или голосовое — заменю содержимое заметки.")
+			msg.ReplyMarkup = kb
+			_, _ = bot.Send(msg)
+		case strings.HasPrefix(data, "note:delete:"):
+			idStr := strings.TrimPrefix(data, "note:delete:")
+			id, _ := strconv.ParseInt(idStr, 10, 64)
+			if err := softDeleteNote(ctx, db, userID, id); err != nil {
+				_, _ = bot.Send(tgbotapi.NewMessage(chatID, "Не смог удалить: "+err.Error()))
+				return
+			}
+			_, _ = bot.Send(tgbotapi.NewMessage(chatID, "🗑 Заметка перемещена в корзину. Восстановить можно там же."))
+		case strings.HasPrefix(data, "note:restore:"):
+			idStr := strings.TrimPrefix(data, "note:restore:")
+			id, _ := strconv.ParseInt(idStr, 10, 64)
+			if err := restoreNote(ctx, db, userID, id); err != nil {
+				_, _ = bot.Send(tgbotapi.NewMessage(chatID, "Не смог восстановить: "+err.Error()))
+				return
+			}
+			_, _ = bot.Send(tgbotapi.NewMessage(chatID, "♻️ Заметка восстановлена."))
+		case strings.HasPrefix(data, "note:purge:"):
+			idStr := strings.TrimPrefix(data, "note:purge:")
+			id, _ := strconv.ParseInt(idStr, 10, 64)
+			if err := purgeNote(ctx, db, userID, id); err != nil {
+				_, _ = bot.Send(tgbotapi.NewMessage(chatID, "Не смог удалить навсегда: "+err.Error()))
+				return
+			}
+			_, _ = bot.Send(tgbotapi.NewMessage(chatID, "❌ Заметка удалена навсегда."))
+		case strings.HasPrefix(data, "note:"):
+			if a.checkRateLimit(chatID, userID, actionNoteRead) {
+				return
+			}
 			idStr := strings.TrimPrefix(data, "note:")
 			id, _ := strconv.ParseInt(idStr, 10, 64)
 			payload, createdAt, err := loadNote(ctx, db, key, userID, id)
@@ -263,127 +506,68 @@ func handleCallback(ctx context.Context, bot *tgbotapi.BotAPI, db *sql.DB, key [
 				return
 			}
 			sendNote(bot, chatID, payload, createdAt, kb)
-		}
-	}
-}
-
-// ---------- Commands workaround for create_note ----------
-// Add this to message handler: command /create_note sets mode.
-// For simplicity, we parse it here in messageToPayload flow by intercepting in handleMessage.
-// To keep single-file easy, we do it with a helper:
 
-func messageToPayload(m *tgbotapi.Message) (NotePayload, error) {
-	if m.Voice != nil {
-		return NotePayload{Kind: "voice", FileID: m.Voice.FileID}, nil
-	}
-	if len(m.Photo) > 0 {
-		best := m.Photo[len(m.Photo)-1] // largest
-		return NotePayload{Kind: "photo", FileID: best.FileID, Caption: m.Caption}, nil
-	}
-	if strings.TrimSpace(m.Text) != "" {
-		return NotePayload{Kind: "text", Text: m.Text}, nil
-	}
-	return NotePayload{}, errors.New("unsupported")
-}
-
-func sendNote(bot *tgbotapi.BotAPI, chatID int64, p NotePayload, createdAt time.Time, kb tgbotapi.ReplyKeyboardMarkup) {
-	header := "🗒 " + createdAt.Format("2006-01-02 15:04:05")
-
-	switch p.Kind {
-	case "text":
-		msg := tgbotapi.NewMessage(chatID, header+"\n\n"+p.Text)
-		msg.ReplyMarkup = kb
-		_, _ = bot.Send(msg)
-	case "photo":
-		pc := tgbotapi.NewPhoto(chatID, tgbotapi.FileID(p.FileID))
-		if strings.TrimSpace(p.Caption) != "" {
-			pc.Caption = header + "\n" + p.Caption
-		} else {
-			pc.Caption = header
-		}
-		pc.ReplyMarkup = kb
-		_, _ = bot.Send(pc)
-	case "voice":
-		vc := tgbotapi.NewVoice(chatID, tgbotapi.FileID(p.FileID))
-		vc.Caption = header
-		vc.ReplyMarkup = kb
-		_, _ = bot.Send(vc)
-	default:
-		msg := tgbotapi.NewMessage(chatID, header+"\n\n(неизвестный тип заметки)")
-		msg.ReplyMarkup = kb
-		_, _ = bot.Send(msg)
+			manage := tgbotapi.NewInlineKeyboardMarkup(tgbotapi.NewInlineKeyboardRow(
+				tgbotapi.NewInlineKeyboardButtonData("✏️ Изменить", "note:edit:"+idStr),
+				tgbotapi.NewInlineKeyboardButtonData("🗑 Удалить", "note:delete:"+idStr),
+			))
+			manageMsg := tgbotapi.NewMessage(chatID, "Управление заметкой:")
+			manageMsg.ReplyMarkup = manage
+			_, _ = bot.Send(manageMsg)
+		case strings.HasPrefix(data, "pass:"):
+			a.handlePassConfigCallback(ctx, cq, data)
+		}
 	}
 }
 
-func migrate(db *sql.DB) error {
-	_, err := db.Exec(`
-CREATE TABLE IF NOT EXISTS notes (
-  id INTEGER PRIMARY KEY AUTOINCREMENT,
-  user_id INTEGER NOT NULL,
-  created_at TEXT NOT NULL,
-  payload_enc BLOB NOT NULL
-);
-CREATE INDEX IF NOT EXISTS idx_notes_user_time ON notes(user_id, created_at);
-`)
-	return err
-}
-
-func saveNote(ctx context.Context, db *sql.DB, key []byte, userID int64, payload NotePayload) error {
-	raw, err := json.Marshal(payload)
-	if err != nil {
-		return err
-	}
-	enc, err := encryptAESGCM(key, raw)
+// handlePassConfigCallback applies a pass:len:/pass:toggle:/pass:mode:/pass:words:
+// callback to the user's in-progress config and redraws the keyboard in
+// place so the user can keep tweaking without a new message each tap.
+func (a *App) handlePassConfigCallback(ctx context.Context, cq *tgbotapi.CallbackQuery, data string) {
+	bot, store := a.bot, a.store
+	userID := cq.From.ID
+	st, err := store.Get(ctx, userID)
 	if err != nil {
-		return err
+		return
 	}
-	_, err = db.ExecContext(ctx,
-		`INSERT INTO notes(user_id, created_at, payload_enc) VALUES(?,?,?)`,
-		userID, time.Now().UTC().Format(time.RFC3339Nano), enc,
-	)
-	return err
-}
-
-func listNotes(ctx context.Context, db *sql.DB, userID int64, limit int) ([]NoteRow, error) {
-	rows, err := db.QueryContext(ctx, `SELECT id, created_at FROM notes WHERE user_id=? ORDER BY created_at DESC LIMIT ?`, userID, limit)
-	if err != nil {
-		return nil, err
+	if st.Context == nil {
+		st.Context = map[string]string{}
 	}
-	defer rows.Close()
+	cfg := passConfigFromContext(st.Context)
 
-	var out []NoteRow
-	for rows.Next() {
-		var id int64
-		var tStr string
-		if err := rows.Scan(&id, &tStr); err != nil {
-			return nil, err
+	switch {
+	case strings.HasPrefix(data, "pass:len:"):
+		if n, err := strconv.Atoi(strings.TrimPrefix(data, "pass:len:")); err == nil {
+			cfg.Length = n
+		}
+	case strings.HasPrefix(data, "pass:toggle:"):
+		switch strings.TrimPrefix(data, "pass:toggle:") {
+		case "lower":
+			cfg.Lower = !cfg.Lower
+		case "upper":
+			cfg.Upper = !cfg.Upper
+		case "digit":
+			cfg.Digit = !cfg.Digit
+		case "symbol":
+			cfg.Symbol = !cfg.Symbol
+		case "ambig":
+			cfg.NoAmbiguous = !cfg.NoAmbiguous
 		}
-		t, _ := time.Parse(time.RFC3339Nano, tStr)
-		out = append(out, NoteRow{ID: id, CreatedAt: t.Local()})
+	case strings.HasPrefix(data, "pass:mode:"):
+		cfg.Passphrase = strings.TrimPrefix(data, "pass:mode:") == "passphrase"
+	case strings.HasPrefix(data, "pass:words:"):
+		if n, err := strconv.Atoi(strings.TrimPrefix(data, "pass:words:")); err == nil {
+			cfg.Words = n
+		}
+	default:
+		return
 	}
-	return out, rows.Err()
-}
 
-func loadNote(ctx context.Context, db *sql.DB, key []byte, userID, noteID int64) (NotePayload, time.Time, error) {
-	var enc []byte
-	var tStr string
-	err := db.QueryRowContext(ctx,
-		`SELECT created_at, payload_enc FROM notes WHERE id=? AND user_id=?`,
-		noteID, userID,
-	).Scan(&tStr, &enc)
-	if err != nil {
-		return NotePayload{}, time.Time{}, err
-	}
-	raw, err := decryptAESGCM(key, enc)
-	if err != nil {
-		return NotePayload{}, time.Time{}, err
-	}
-	var p NotePayload
-	if err := json.Unmarshal(raw, &p); err != nil {
-		return NotePayload{}, time.Time{}, err
-	}
-	t, _ := time.Parse(time.RFC3339Nano, tStr)
-	return p, t.Local(), nil
+	cfg.saveToContext(st.Context)
+	_ = store.Set(ctx, userID, st)
+
+	edit := tgbotapi.NewEditMessageReplyMarkup(cq.Message.Chat.ID, cq.Message.MessageID, passConfigKeyboard(cfg))
+	_, _ = bot.Request(edit)
 }
 
 // ---- crypto AES-GCM ----
@@ -423,31 +607,7 @@ func decryptAESGCM(key, data []byte) ([]byte, error) {
 	return gcm.Open(nil, nonce, ct, nil)
 }
 
-// ---- password ----
-func genPassword8() string {
-	lower := "abcdefghijklmnopqrstuvwxyz"
-	upper := "ABCDEFGHIJKLMNOPQRSTUVWXYZ"
-	digs := "0123456789"
-	spec := "!@#$%^&*()-_=+[]{};:,.<>?"
-	all := lower + upper + digs + spec
-
-	// Ensure all categories present: 1 lower, 1 upper, 1 digit, 1 spec + 4 random
-	var b []byte
-	b = append(b, lower[randInt(len(lower))])
-	b = append(b, upper[randInt(len(upper))])
-	b = append(b, digs[randInt(len(digs))])
-	b = append(b, spec[randInt(len(spec))])
-	for len(b) < 8 {
-		b = append(b, all[randInt(len(all))])
-	}
-	// Shuffle
-	for i := len(b) - 1; i > 0; i-- {
-		j := randInt(i + 1)
-		b[i], b[j] = b[j], b[i]
-	}
-	return string(b)
-}
-
+// ---- shared randomness ----
 func randInt(n int) int {
 	if n <= 0 {
 		return 0
@@ -462,191 +622,6 @@ func randInt(n int) int {
 	return v % n
 }
 
-// ---- calculator: + - * / parentheses, floats ----
-func evalExpr(s string) (float64, error) {
-	toks, err := tokenize(s)
-	if err != nil {
-		return 0, err
-	}
-	rpn, err := shuntingYard(toks)
-	if err != nil {
-		return 0, err
-	}
-	return evalRPN(rpn)
-}
-
-type tokType int
-
-const (
-	tNumber tokType = iota
-	tOp
-	tLParen
-	tRParen
-)
-
-type token struct {
-	typ tokType
-	val string
-}
-
-func tokenize(s string) ([]token, error) {
-	s = strings.ReplaceAll(s, " ", "")
-	if s == "" {
-		return nil, errors.New("empty expression")
-	}
-	var out []token
-	i := 0
-	for i < len(s) {
-		c := s[i]
-		switch {
-		case (c >= '0' && c <= '9') || c == '.':
-			j := i + 1
-			for j < len(s) && ((s[j] >= '0' && s[j] <= '9') || s[j] == '.') {
-				j++
-			}
-			out = append(out, token{typ: tNumber, val: s[i:j]})
-			i = j
-		case c == '+' || c == '-' || c == '*' || c == '/':
-			out = append(out, token{typ: tOp, val: string(c)})
-			i++
-		case c == '(':
-			out = append(out, token{typ: tLParen, val: "("})
-			i++
-		case c == ')':
-			out = append(out, token{typ: tRParen, val: ")"})
-			i++
-		default:
-			return nil, fmt.Errorf("bad char: %q", c)
-		}
-	}
-	// Handle unary minus by rewriting: (-x) or at start -> (0-x)
-	out = rewriteUnaryMinus(out)
-	return out, nil
-}
-
-func rewriteUnaryMinus(toks []token) []token {
-	var out []token
-	for i := 0; i < len(toks); i++ {
-		t := toks[i]
-		if t.typ == tOp && t.val == "-" {
-			if i == 0 || toks[i-1].typ == tOp || toks[i-1].typ == tLParen {
-				// unary minus -> 0 - ...
-				out = append(out, token{typ: tNumber, val: "0"})
-			}
-		}
-		out = append(out, t)
-	}
-	return out
-}
-
-func prec(op string) int {
-	switch op {
-	case "+", "-":
-		return 1
-	case "*", "/":
-		return 2
-	default:
-		return 0
-	}
-}
-
-func shuntingYard(toks []token) ([]token, error) {
-	var out []token
-	var stack []token
-	for _, t := range toks {
-		switch t.typ {
-		case tNumber:
-			out = append(out, t)
-		case tOp:
-			for len(stack) > 0 {
-				top := stack[len(stack)-1]
-				if top.typ == tOp && prec(top.val) >= prec(t.val) {
-					out = append(out, top)
-					stack = stack[:len(stack)-1]
-				} else {
-					break
-				}
-			}
-			stack = append(stack, t)
-		case tLParen:
-			stack = append(stack, t)
-		case tRParen:
-			found := false
-			for len(stack) > 0 {
-				top := stack[len(stack)-1]
-				stack = stack[:len(stack)-1]
-				if top.typ == tLParen {
-					found = true
-					break
-				}
-				out = append(out, top)
-			}
-			if !found {
-				return nil, errors.New("mismatched parentheses")
-			}
-		}
-	}
-	for len(stack) > 0 {
-		top := stack[len(stack)-1]
-		stack = stack[:len(stack)-1]
-		if top.typ == tLParen || top.typ == tRParen {
-			return nil, errors.New("mismatched parentheses")
-		}
-		out = append(out, top)
-	}
-	return out, nil
-}
-
-func evalRPN(toks []token) (float64, error) {
-	var st []float64
-	for _, t := range toks {
-		if t.typ == tNumber {
-			v, err := strconv.ParseFloat(t.val, 64)
-			if err != nil {
-				return 0, errors.New("bad number")
-			}
-			st = append(st, v)
-			continue
-		}
-		if t.typ == tOp {
-			if len(st) < 2 {
-				return 0, errors.New("bad expression")
-			}
-			b := st[len(st)-1]
-			a := st[len(st)-2]
-			st = st[:len(st)-2]
-			var r float64
-			switch t.val {
-			case "+":
-				r = a + b
-			case "-":
-				r = a - b
-			case "*":
-				r = a * b
-			case "/":
-				if b == 0 {
-					return 0, errors.New("division by zero")
-				}
-				r = a / b
-			}
-			st = append(st, r)
-		}
-	}
-	if len(st) != 1 {
-		return 0, errors.New("bad expression")
-	}
-	if math.IsInf(st[0], 0) || math.IsNaN(st[0]) {
-		return 0, errors.New("bad result")
-	}
-	return st[0], nil
-}
-
-func trimFloat(v float64) string {
-	// Pretty format: remove trailing zeros
-	s := strconv.FormatFloat(v, 'f', -1, 64)
-	return s
-}
-
 // ---- env helpers ----
 func mustEnv(k string) string {
 	v := strings.TrimSpace(os.Getenv(k))
@@ -663,3 +638,15 @@ func getenvDefault(k, d string) string {
 	}
 	return v
 }
+
+func mustEnvIntDefault(k string, d int) int {
+	v := strings.TrimSpace(os.Getenv(k))
+	if v == "" {
+		return d
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		log.Fatalf("invalid int env %s=%q: %v", k, v, err)
+	}
+	return n
+}
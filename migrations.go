@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"embed"
+	"fmt"
+	"io/fs"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//go:embed migrations/*.sql
+var migrationsFS embed.FS
+
+// migration is one "NNNN_name.up.sql" file embedded at build time.
+type migration struct {
+	Version  int
+	Name     string
+	SQL      string
+	Checksum string
+}
+
+// loadMigrations reads every embedded *.up.sql file and returns them sorted
+// by version.
+func loadMigrations() ([]migration, error) {
+	entries, err := fs.ReadDir(migrationsFS, "migrations")
+	if err != nil {
+		return nil, err
+	}
+	var out []migration
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasSuffix(e.Name(), ".up.sql") {
+			continue
+		}
+		version, name, err := parseMigrationFilename(e.Name())
+		if err != nil {
+			return nil, err
+		}
+		raw, err := migrationsFS.ReadFile("migrations/" + e.Name())
+		if err != nil {
+			return nil, err
+		}
+		sum := sha256.Sum256(raw)
+		out = append(out, migration{
+			Version:  version,
+			Name:     name,
+			SQL:      string(raw),
+			Checksum: fmt.Sprintf("%x", sum),
+		})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Version < out[j].Version })
+	return out, nil
+}
+
+// parseMigrationFilename extracts the version and name from a
+// "0001_create_notes.up.sql"-style filename.
+func parseMigrationFilename(filename string) (version int, name string, err error) {
+	base := strings.TrimSuffix(filename, ".up.sql")
+	parts := strings.SplitN(base, "_", 2)
+	if len(parts) != 2 {
+		return 0, "", fmt.Errorf("malformed migration filename: %s", filename)
+	}
+	version, err = strconv.Atoi(parts[0])
+	if err != nil {
+		return 0, "", fmt.Errorf("malformed migration filename: %s", filename)
+	}
+	return version, parts[1], nil
+}
+
+// runMigrations applies every embedded migration not yet recorded in
+// schema_migrations, each inside its own transaction. If a migration already
+// recorded as applied no longer matches its embedded checksum, it fails
+// loudly instead of silently drifting from what's actually in the database.
+func runMigrations(ctx context.Context, db *sql.DB) error {
+	if _, err := db.ExecContext(ctx, `
+CREATE TABLE IF NOT EXISTS schema_migrations (
+  version INTEGER PRIMARY KEY,
+  name TEXT NOT NULL,
+  checksum TEXT NOT NULL,
+  applied_at TEXT NOT NULL
+);
+`); err != nil {
+		return err
+	}
+
+	migrations, err := loadMigrations()
+	if err != nil {
+		return err
+	}
+
+	applied := map[int]string{}
+	rows, err := db.QueryContext(ctx, `SELECT version, checksum FROM schema_migrations`)
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var v int
+		var sum string
+		if err := rows.Scan(&v, &sum); err != nil {
+			rows.Close()
+			return err
+		}
+		applied[v] = sum
+	}
+	if err := rows.Err(); err != nil {
+		rows.Close()
+		return err
+	}
+	rows.Close()
+
+	for _, m := range migrations {
+		if sum, ok := applied[m.Version]; ok {
+			if sum != m.Checksum {
+				return fmt.Errorf("migration %04d_%s: checksum mismatch (applied db=%s, binary=%s) — an applied migration must never change", m.Version, m.Name, sum, m.Checksum)
+			}
+			continue
+		}
+
+		tx, err := db.BeginTx(ctx, nil)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, m.SQL); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("migration %04d_%s: %w", m.Version, m.Name, err)
+		}
+		if _, err := tx.ExecContext(ctx,
+			`INSERT INTO schema_migrations(version, name, checksum, applied_at) VALUES(?,?,?,?)`,
+			m.Version, m.Name, m.Checksum, time.Now().UTC().Format(time.RFC3339Nano),
+		); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("migration %04d_%s: %w", m.Version, m.Name, err)
+		}
+		if err := tx.Commit(); err != nil {
+			return fmt.Errorf("migration %04d_%s: %w", m.Version, m.Name, err)
+		}
+	}
+	return nil
+}
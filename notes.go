@@ -0,0 +1,461 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+type NotePayload struct {
+	Kind       string `json:"kind"`                  // "text" | "photo" | "voice" | "password"
+	Text       string `json:"text,omitempty"`        // for text, or the secret for password
+	FileID     string `json:"file_id,omitempty"`     // for photo/voice
+	Caption    string `json:"caption,omitempty"`     // optional
+	Duration   int    `json:"duration,omitempty"`    // voice: seconds, from Telegram
+	MimeType   string `json:"mime_type,omitempty"`   // voice: from Telegram
+	Transcript string `json:"transcript,omitempty"` // voice: filled in by a Transcriber, if configured
+}
+
+type NoteRow struct {
+	ID        int64
+	CreatedAt time.Time
+	UpdatedAt time.Time
+	DeletedAt time.Time // zero unless the row came from listDeletedNotes
+}
+
+func messageToPayload(m *tgbotapi.Message) (NotePayload, error) {
+	if m.Voice != nil {
+		return NotePayload{
+			Kind:     "voice",
+			FileID:   m.Voice.FileID,
+			Duration: m.Voice.Duration,
+			MimeType: m.Voice.MimeType,
+		}, nil
+	}
+	if len(m.Photo) > 0 {
+		best := m.Photo[len(m.Photo)-1] // largest
+		return NotePayload{Kind: "photo", FileID: best.FileID, Caption: m.Caption}, nil
+	}
+	if strings.TrimSpace(m.Text) != "" {
+		return NotePayload{Kind: "text", Text: m.Text}, nil
+	}
+	return NotePayload{}, errors.New("unsupported")
+}
+
+func sendNote(bot *tgbotapi.BotAPI, chatID int64, p NotePayload, createdAt time.Time, kb tgbotapi.ReplyKeyboardMarkup) {
+	header := "🗒 " + createdAt.Format("2006-01-02 15:04:05")
+
+	switch p.Kind {
+	case "text":
+		msg := tgbotapi.NewMessage(chatID, header+"\n\n"+p.Text)
+		msg.ReplyMarkup = kb
+		_, _ = bot.Send(msg)
+	case "photo":
+		pc := tgbotapi.NewPhoto(chatID, tgbotapi.FileID(p.FileID))
+		if strings.TrimSpace(p.Caption) != "" {
+			pc.Caption = header + "\n" + p.Caption
+		} else {
+			pc.Caption = header
+		}
+		pc.ReplyMarkup = kb
+		_, _ = bot.Send(pc)
+	case "voice":
+		vc := tgbotapi.NewVoice(chatID, tgbotapi.FileID(p.FileID))
+		vc.Caption = header
+		vc.ReplyMarkup = kb
+		_, _ = bot.Send(vc)
+		if strings.TrimSpace(p.Transcript) != "" {
+			msg := tgbotapi.NewMessage(chatID, "📝 Расшифровка:\n"+p.Transcript)
+			msg.ReplyMarkup = kb
+			_, _ = bot.Send(msg)
+		}
+	case "password":
+		title := p.Caption
+		if strings.TrimSpace(title) == "" {
+			title = "(без названия)"
+		}
+		msg := tgbotapi.NewMessage(chatID, header+"\n"+title+"\n\n`"+p.Text+"`")
+		msg.ParseMode = "Markdown"
+		msg.ReplyMarkup = kb
+		_, _ = bot.Send(msg)
+	default:
+		msg := tgbotapi.NewMessage(chatID, header+"\n\n(неизвестный тип заметки)")
+		msg.ReplyMarkup = kb
+		_, _ = bot.Send(msg)
+	}
+}
+
+// The notes table itself is created by the migration runner (see
+// migrations.go).
+
+// noteSearchableText returns the plaintext worth indexing for full-text
+// search. Kinds without text content (a bare voice note, for now) index as
+// empty and simply won't match any term.
+func noteSearchableText(p NotePayload) string {
+	switch p.Kind {
+	case "text":
+		return p.Text
+	case "photo":
+		return p.Caption
+	case "voice":
+		return p.Transcript
+	case "password":
+		// Index only the label, never the secret itself.
+		return p.Caption
+	default:
+		return ""
+	}
+}
+
+// saveNote encrypts and stores payload, then populates the search index
+// with HMACed tokens derived from its plaintext. Returns the new note's ID.
+func saveNote(ctx context.Context, db *sql.DB, key []byte, userID int64, payload NotePayload) (int64, error) {
+	raw, err := json.Marshal(payload)
+	if err != nil {
+		return 0, err
+	}
+	enc, err := encryptAESGCM(key, raw)
+	if err != nil {
+		return 0, err
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx,
+		`INSERT INTO notes(user_id, created_at, payload_enc) VALUES(?,?,?)`,
+		userID, time.Now().UTC().Format(time.RFC3339Nano), enc,
+	)
+	if err != nil {
+		return 0, err
+	}
+	noteID, err := res.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+	if err := indexNoteText(ctx, tx, key, noteID, userID, noteSearchableText(payload)); err != nil {
+		return 0, err
+	}
+	return noteID, tx.Commit()
+}
+
+// saveNoteWithQuota enforces a.noteLimits (overridable per-user via
+// user_limits) before delegating to saveNote, so a runaway script can't
+// fill the database with encrypted notes. Returns the new note's ID.
+func (a *App) saveNoteWithQuota(ctx context.Context, userID int64, payload NotePayload) (int64, error) {
+	lim, err := loadUserLimits(ctx, a.db, userID, a.noteLimits)
+	if err != nil {
+		return 0, err
+	}
+	count, totalBytes, err := noteUsage(ctx, a.db, userID)
+	if err != nil {
+		return 0, err
+	}
+	if lim.MaxNotes > 0 && count >= lim.MaxNotes {
+		return 0, fmt.Errorf("превышен лимит заметок (%d)", lim.MaxNotes)
+	}
+	raw, err := json.Marshal(payload)
+	if err != nil {
+		return 0, err
+	}
+	if lim.MaxTotalBytes > 0 && totalBytes+int64(len(raw)) > lim.MaxTotalBytes {
+		return 0, fmt.Errorf("превышен лимит объёма заметок (%d байт)", lim.MaxTotalBytes)
+	}
+	return saveNote(ctx, a.db, a.key, userID, payload)
+}
+
+// listNotes returns userID's non-deleted notes, newest-edited first.
+// saveNote leaves updated_at NULL until a note's first edit, so
+// COALESCE(updated_at, created_at) falls back to the creation time for
+// notes that were never edited.
+func listNotes(ctx context.Context, db *sql.DB, userID int64, limit int) ([]NoteRow, error) {
+	rows, err := db.QueryContext(ctx,
+		`SELECT id, created_at, COALESCE(updated_at, created_at) FROM notes
+		 WHERE user_id=? AND deleted_at IS NULL ORDER BY COALESCE(updated_at, created_at) DESC LIMIT ?`,
+		userID, limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []NoteRow
+	for rows.Next() {
+		var id int64
+		var cStr, uStr string
+		if err := rows.Scan(&id, &cStr, &uStr); err != nil {
+			return nil, err
+		}
+		c, _ := time.Parse(time.RFC3339Nano, cStr)
+		u, _ := time.Parse(time.RFC3339Nano, uStr)
+		out = append(out, NoteRow{ID: id, CreatedAt: c.Local(), UpdatedAt: u.Local()})
+	}
+	return out, rows.Err()
+}
+
+// listDeletedNotes returns userID's soft-deleted notes, most recently
+// deleted first, for the trash view.
+func listDeletedNotes(ctx context.Context, db *sql.DB, userID int64, limit int) ([]NoteRow, error) {
+	rows, err := db.QueryContext(ctx,
+		`SELECT id, created_at, deleted_at FROM notes
+		 WHERE user_id=? AND deleted_at IS NOT NULL ORDER BY deleted_at DESC LIMIT ?`,
+		userID, limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []NoteRow
+	for rows.Next() {
+		var id int64
+		var cStr, dStr string
+		if err := rows.Scan(&id, &cStr, &dStr); err != nil {
+			return nil, err
+		}
+		c, _ := time.Parse(time.RFC3339Nano, cStr)
+		d, _ := time.Parse(time.RFC3339Nano, dStr)
+		out = append(out, NoteRow{ID: id, CreatedAt: c.Local(), DeletedAt: d.Local()})
+	}
+	return out, rows.Err()
+}
+
+func loadNote(ctx context.Context, db *sql.DB, key []byte, userID, noteID int64) (NotePayload, time.Time, error) {
+	var enc []byte
+	var tStr string
+	err := db.QueryRowContext(ctx,
+		`SELECT created_at, payload_enc FROM notes WHERE id=? AND user_id=?`,
+		noteID, userID,
+	).Scan(&tStr, &enc)
+	if err != nil {
+		return NotePayload{}, time.Time{}, err
+	}
+	raw, err := decryptAESGCM(key, enc)
+	if err != nil {
+		return NotePayload{}, time.Time{}, err
+	}
+	var p NotePayload
+	if err := json.Unmarshal(raw, &p); err != nil {
+		return NotePayload{}, time.Time{}, err
+	}
+	t, _ := time.Parse(time.RFC3339Nano, tStr)
+	return p, t.Local(), nil
+}
+
+// editNote archives the current head as a note_revisions row, then
+// overwrites notes with payload as the new head and re-indexes its
+// searchable text. noteID must belong to userID and not be in the trash.
+func editNote(ctx context.Context, db *sql.DB, key []byte, userID, noteID int64, payload NotePayload) error {
+	raw, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	enc, err := encryptAESGCM(key, raw)
+	if err != nil {
+		return err
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var oldEnc []byte
+	var headAt string
+	err = tx.QueryRowContext(ctx,
+		`SELECT payload_enc, COALESCE(updated_at, created_at) FROM notes WHERE id=? AND user_id=? AND deleted_at IS NULL`,
+		noteID, userID,
+	).Scan(&oldEnc, &headAt)
+	if err != nil {
+		return err
+	}
+
+	if _, err := tx.ExecContext(ctx,
+		`INSERT INTO note_revisions(note_id, created_at, payload_enc) VALUES(?,?,?)`,
+		noteID, headAt, oldEnc,
+	); err != nil {
+		return err
+	}
+
+	res, err := tx.ExecContext(ctx,
+		`UPDATE notes SET payload_enc=?, updated_at=? WHERE id=? AND user_id=? AND deleted_at IS NULL`,
+		enc, time.Now().UTC().Format(time.RFC3339Nano), noteID, userID,
+	)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return errors.New("заметка не найдена")
+	}
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM notes_index WHERE note_id=?`, noteID); err != nil {
+		return err
+	}
+	if err := indexNoteText(ctx, tx, key, noteID, userID, noteSearchableText(payload)); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// attachTranscript fills in a voice note's transcript after the fact (it
+// arrives asynchronously, once transcription finishes) and refreshes its
+// search index entry. Unlike editNote, it doesn't archive a revision: a
+// transcript arriving late isn't a user edit.
+func attachTranscript(ctx context.Context, db *sql.DB, key []byte, userID, noteID int64, transcript string) error {
+	payload, _, err := loadNote(ctx, db, key, userID, noteID)
+	if err != nil {
+		return err
+	}
+	payload.Transcript = transcript
+	raw, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	enc, err := encryptAESGCM(key, raw)
+	if err != nil {
+		return err
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx,
+		`UPDATE notes SET payload_enc=? WHERE id=? AND user_id=? AND deleted_at IS NULL`,
+		enc, noteID, userID,
+	)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return errors.New("заметка не найдена")
+	}
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM notes_index WHERE note_id=?`, noteID); err != nil {
+		return err
+	}
+	if err := indexNoteText(ctx, tx, key, noteID, userID, noteSearchableText(payload)); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// editNoteWithQuota enforces a.noteLimits' byte quota (an edit doesn't
+// change the note count) before delegating to editNote.
+func (a *App) editNoteWithQuota(ctx context.Context, userID, noteID int64, payload NotePayload) error {
+	lim, err := loadUserLimits(ctx, a.db, userID, a.noteLimits)
+	if err != nil {
+		return err
+	}
+	_, totalBytes, err := noteUsage(ctx, a.db, userID)
+	if err != nil {
+		return err
+	}
+	raw, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	if lim.MaxTotalBytes > 0 && totalBytes+int64(len(raw)) > lim.MaxTotalBytes {
+		return fmt.Errorf("превышен лимит объёма заметок (%d байт)", lim.MaxTotalBytes)
+	}
+	return editNote(ctx, a.db, a.key, userID, noteID, payload)
+}
+
+// softDeleteNote marks noteID as deleted without touching its payload or
+// search index, so restoreNote can bring it back unchanged.
+func softDeleteNote(ctx context.Context, db *sql.DB, userID, noteID int64) error {
+	res, err := db.ExecContext(ctx,
+		`UPDATE notes SET deleted_at=? WHERE id=? AND user_id=? AND deleted_at IS NULL`,
+		time.Now().UTC().Format(time.RFC3339Nano), noteID, userID,
+	)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return errors.New("заметка не найдена")
+	}
+	return nil
+}
+
+// restoreNote undoes softDeleteNote.
+func restoreNote(ctx context.Context, db *sql.DB, userID, noteID int64) error {
+	res, err := db.ExecContext(ctx,
+		`UPDATE notes SET deleted_at=NULL WHERE id=? AND user_id=? AND deleted_at IS NOT NULL`,
+		noteID, userID,
+	)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return errors.New("заметка не найдена в корзине")
+	}
+	return nil
+}
+
+// purgeNote permanently removes a trashed note along with its revision
+// history and search index entries. It refuses to purge a note that isn't
+// already soft-deleted, so "purge" can't be used to skip the trash step.
+func purgeNote(ctx context.Context, db *sql.DB, userID, noteID int64) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx,
+		`DELETE FROM notes WHERE id=? AND user_id=? AND deleted_at IS NOT NULL`,
+		noteID, userID,
+	)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return errors.New("заметка не найдена в корзине")
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM note_revisions WHERE note_id=?`, noteID); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM notes_index WHERE note_id=?`, noteID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// notesInlineList renders notes as one inline button per row, newest first,
+// for use by both the plain "recent notes" view and search results.
+func notesInlineList(rows []NoteRow) tgbotapi.InlineKeyboardMarkup {
+	var buttons [][]tgbotapi.InlineKeyboardButton
+	for _, r := range rows {
+		title := r.CreatedAt.Format("2006-01-02 15:04:05")
+		btn := tgbotapi.NewInlineKeyboardButtonData("🗒 "+title, "note:"+strconv.FormatInt(r.ID, 10))
+		buttons = append(buttons, tgbotapi.NewInlineKeyboardRow(btn))
+	}
+	return tgbotapi.NewInlineKeyboardMarkup(buttons...)
+}
+
+// trashInlineList renders deleted notes with restore/purge buttons per row.
+func trashInlineList(rows []NoteRow) tgbotapi.InlineKeyboardMarkup {
+	var buttons [][]tgbotapi.InlineKeyboardButton
+	for _, r := range rows {
+		idStr := strconv.FormatInt(r.ID, 10)
+		title := r.CreatedAt.Format("2006-01-02 15:04:05")
+		buttons = append(buttons, tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("♻️ "+title, "note:restore:"+idStr),
+			tgbotapi.NewInlineKeyboardButtonData("❌ Удалить навсегда", "note:purge:"+idStr),
+		))
+	}
+	return tgbotapi.NewInlineKeyboardMarkup(buttons...)
+}
@@ -0,0 +1,287 @@
+package main
+
+import (
+	_ "embed"
+	"encoding/base64"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+//go:embed wordlist.txt
+var wordlistRaw string
+
+// wordlist is a diceware-style word pool for passphrase mode: a curated list
+// of common English words, not the official EFF wordlist. At ~2000 entries
+// it gives around 11 bits of entropy per word — entropyLabel reflects the
+// real number, so a short passphrase is still correctly flagged as weak.
+var wordlist = strings.Fields(wordlistRaw)
+
+const ambiguousChars = "0O1lI"
+
+const (
+	classLower  = "abcdefghijklmnopqrstuvwxyz"
+	classUpper  = "ABCDEFGHIJKLMNOPQRSTUVWXYZ"
+	classDigit  = "0123456789"
+	classSymbol = "!@#$%^&*()-_=+[]{};:,.<>?"
+)
+
+type passConfig struct {
+	Length      int
+	Lower       bool
+	Upper       bool
+	Digit       bool
+	Symbol      bool
+	NoAmbiguous bool
+	Passphrase  bool
+	Words       int
+}
+
+func defaultPassConfig() passConfig {
+	return passConfig{Length: 16, Lower: true, Upper: true, Digit: true, Symbol: true, Words: 4}
+}
+
+// passConfigFromContext loads a passConfig from a UserState context map,
+// falling back to defaults for anything missing.
+func passConfigFromContext(c map[string]string) passConfig {
+	cfg := defaultPassConfig()
+	if v, ok := c["pass:length"]; ok {
+		if n, err := strconv.Atoi(v); err == nil {
+			cfg.Length = n
+		}
+	}
+	if v, ok := c["pass:lower"]; ok {
+		cfg.Lower = v == "1"
+	}
+	if v, ok := c["pass:upper"]; ok {
+		cfg.Upper = v == "1"
+	}
+	if v, ok := c["pass:digit"]; ok {
+		cfg.Digit = v == "1"
+	}
+	if v, ok := c["pass:symbol"]; ok {
+		cfg.Symbol = v == "1"
+	}
+	if v, ok := c["pass:noambig"]; ok {
+		cfg.NoAmbiguous = v == "1"
+	}
+	if v, ok := c["pass:passphrase"]; ok {
+		cfg.Passphrase = v == "1"
+	}
+	if v, ok := c["pass:words"]; ok {
+		if n, err := strconv.Atoi(v); err == nil {
+			cfg.Words = n
+		}
+	}
+	return cfg
+}
+
+func boolStr(b bool) string {
+	if b {
+		return "1"
+	}
+	return "0"
+}
+
+func (cfg passConfig) saveToContext(c map[string]string) {
+	c["pass:length"] = strconv.Itoa(cfg.Length)
+	c["pass:lower"] = boolStr(cfg.Lower)
+	c["pass:upper"] = boolStr(cfg.Upper)
+	c["pass:digit"] = boolStr(cfg.Digit)
+	c["pass:symbol"] = boolStr(cfg.Symbol)
+	c["pass:noambig"] = boolStr(cfg.NoAmbiguous)
+	c["pass:passphrase"] = boolStr(cfg.Passphrase)
+	c["pass:words"] = strconv.Itoa(cfg.Words)
+}
+
+// clearPassContext removes every pass: key, including any pending secret
+// left over from an unsaved generation.
+func clearPassContext(c map[string]string) {
+	for k := range c {
+		if strings.HasPrefix(k, "pass:") {
+			delete(c, k)
+		}
+	}
+}
+
+func check(b bool) string {
+	if b {
+		return "☑"
+	}
+	return "☐"
+}
+
+func mark(selected bool) string {
+	if selected {
+		return "🔘"
+	}
+	return "⚪"
+}
+
+// passConfigKeyboard renders the configuration screen for the password
+// generator as an inline keyboard.
+func passConfigKeyboard(cfg passConfig) tgbotapi.InlineKeyboardMarkup {
+	var rows [][]tgbotapi.InlineKeyboardButton
+
+	if !cfg.Passphrase {
+		var lenRow []tgbotapi.InlineKeyboardButton
+		for _, n := range []int{8, 12, 16, 24, 32} {
+			label := strconv.Itoa(n)
+			if n == cfg.Length {
+				label = "• " + label + " •"
+			}
+			lenRow = append(lenRow, tgbotapi.NewInlineKeyboardButtonData(label, "pass:len:"+strconv.Itoa(n)))
+		}
+		rows = append(rows, lenRow)
+
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(check(cfg.Lower)+" a-z", "pass:toggle:lower"),
+			tgbotapi.NewInlineKeyboardButtonData(check(cfg.Upper)+" A-Z", "pass:toggle:upper"),
+		))
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(check(cfg.Digit)+" 0-9", "pass:toggle:digit"),
+			tgbotapi.NewInlineKeyboardButtonData(check(cfg.Symbol)+" !@#", "pass:toggle:symbol"),
+		))
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(check(cfg.NoAmbiguous)+" без похожих (0/O, 1/l)", "pass:toggle:ambig"),
+		))
+	} else {
+		var wordsRow []tgbotapi.InlineKeyboardButton
+		for _, n := range []int{3, 4, 5, 6} {
+			label := strconv.Itoa(n) + " слов"
+			if n == cfg.Words {
+				label = "• " + label + " •"
+			}
+			wordsRow = append(wordsRow, tgbotapi.NewInlineKeyboardButtonData(label, "pass:words:"+strconv.Itoa(n)))
+		}
+		rows = append(rows, wordsRow)
+	}
+
+	rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData(mark(!cfg.Passphrase)+" Пароль", "pass:mode:charset"),
+		tgbotapi.NewInlineKeyboardButtonData(mark(cfg.Passphrase)+" Фраза", "pass:mode:passphrase"),
+	))
+	rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData("✅ Сгенерировать", "pass:generate"),
+		tgbotapi.NewInlineKeyboardButtonData(btnCancel, "pass:cancel"),
+	))
+	return tgbotapi.NewInlineKeyboardMarkup(rows...)
+}
+
+// charPool builds the character pool for charset mode, returning the class
+// strings that feed it (so generatePassword can guarantee one char from
+// each selected class) and the combined pool.
+func charPool(cfg passConfig) (classes []string, pool string, err error) {
+	strip := func(s string) string {
+		if !cfg.NoAmbiguous {
+			return s
+		}
+		var b strings.Builder
+		for _, r := range s {
+			if !strings.ContainsRune(ambiguousChars, r) {
+				b.WriteRune(r)
+			}
+		}
+		return b.String()
+	}
+	if cfg.Lower {
+		classes = append(classes, strip(classLower))
+	}
+	if cfg.Upper {
+		classes = append(classes, strip(classUpper))
+	}
+	if cfg.Digit {
+		classes = append(classes, strip(classDigit))
+	}
+	if cfg.Symbol {
+		classes = append(classes, strip(classSymbol))
+	}
+	if len(classes) == 0 {
+		return nil, "", fmt.Errorf("выбери хотя бы один тип символов")
+	}
+	for _, c := range classes {
+		pool += c
+	}
+	return classes, pool, nil
+}
+
+// generatePassword produces a secret (either a random-character password or
+// a diceware-style passphrase) and its Shannon entropy in bits.
+func generatePassword(cfg passConfig) (secret string, entropyBits float64, err error) {
+	if cfg.Passphrase {
+		if len(wordlist) == 0 {
+			return "", 0, fmt.Errorf("словарь фраз пуст")
+		}
+		words := make([]string, cfg.Words)
+		for i := range words {
+			words[i] = wordlist[randInt(len(wordlist))]
+		}
+		entropy := math.Log2(float64(len(wordlist))) * float64(cfg.Words)
+		return strings.Join(words, "-"), entropy, nil
+	}
+
+	classes, pool, err := charPool(cfg)
+	if err != nil {
+		return "", 0, err
+	}
+	if cfg.Length < len(classes) {
+		return "", 0, fmt.Errorf("длина должна быть хотя бы %d для выбранных типов символов", len(classes))
+	}
+
+	b := make([]byte, 0, cfg.Length)
+	for _, c := range classes {
+		b = append(b, c[randInt(len(c))])
+	}
+	for len(b) < cfg.Length {
+		b = append(b, pool[randInt(len(pool))])
+	}
+	for i := len(b) - 1; i > 0; i-- {
+		j := randInt(i + 1)
+		b[i], b[j] = b[j], b[i]
+	}
+	entropy := math.Log2(float64(len(pool))) * float64(cfg.Length)
+	return string(b), entropy, nil
+}
+
+// entropyLabel gives a qualitative read on a bit count so users without a
+// security background can tell good from bad at a glance.
+func entropyLabel(bits float64) string {
+	switch {
+	case bits < 40:
+		return "слабый"
+	case bits < 60:
+		return "средний"
+	case bits < 80:
+		return "хороший"
+	case bits < 100:
+		return "сильный"
+	default:
+		return "отличный"
+	}
+}
+
+// encryptSecretForContext encrypts a freshly generated secret so it can sit
+// in user_states.context_json (plaintext storage) until the user saves or
+// discards it.
+func encryptSecretForContext(key []byte, secret string) (string, error) {
+	enc, err := encryptAESGCM(key, []byte(secret))
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(enc), nil
+}
+
+func decryptSecretFromContext(key []byte, encoded string) (string, error) {
+	enc, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", err
+	}
+	raw, err := decryptAESGCM(key, enc)
+	if err != nil {
+		return "", err
+	}
+	return string(raw), nil
+}
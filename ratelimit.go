@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Clock abstracts time.Now so RateLimiter implementations can be driven by a
+// fake clock in tests instead of real wall time.
+type Clock interface {
+	Now() time.Time
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time { return time.Now() }
+
+// RateLimiter decides whether userID may perform action right now. When it
+// returns ok == false, retryAfter is how long the caller should wait before
+// trying again.
+type RateLimiter interface {
+	Allow(userID int64, action string) (ok bool, retryAfter time.Duration)
+}
+
+// action names used with RateLimiter.Allow.
+const (
+	actionCalc       = "calc"
+	actionNoteCreate = "note_create"
+	actionNoteRead   = "note_read"
+	actionPassGen    = "pass_gen"
+)
+
+// actionLimits maps an action to its allowed rate (tokens per minute). The
+// bucket capacity equals the per-minute rate, so a user can burst up to a
+// full minute's worth before being throttled.
+var actionLimits = map[string]int{
+	actionCalc:       30,
+	actionNoteCreate: 20,
+	actionNoteRead:   60,
+	actionPassGen:    5,
+}
+
+type bucket struct {
+	tokens     float64
+	lastRefill time.Time
+}
+
+// tokenBucketLimiter implements RateLimiter with one token bucket per
+// (userID, action) pair, refilled continuously based on elapsed clock time.
+type tokenBucketLimiter struct {
+	clock  Clock
+	limits map[string]int
+
+	mu      sync.Mutex
+	buckets map[string]*bucket
+}
+
+// NewTokenBucketLimiter returns a RateLimiter using clock for timing
+// decisions. Pass realClock{} in production; tests can inject a fake Clock.
+func NewTokenBucketLimiter(clock Clock, limits map[string]int) *tokenBucketLimiter {
+	return &tokenBucketLimiter{clock: clock, limits: limits, buckets: map[string]*bucket{}}
+}
+
+func (l *tokenBucketLimiter) Allow(userID int64, action string) (bool, time.Duration) {
+	perMinute, ok := l.limits[action]
+	if !ok || perMinute <= 0 {
+		return true, 0
+	}
+	capacity := float64(perMinute)
+	refillPerSecond := capacity / 60
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	key := fmt.Sprintf("%d:%s", userID, action)
+	now := l.clock.Now()
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: capacity, lastRefill: now}
+		l.buckets[key] = b
+	} else {
+		elapsed := now.Sub(b.lastRefill).Seconds()
+		if elapsed > 0 {
+			b.tokens += elapsed * refillPerSecond
+			if b.tokens > capacity {
+				b.tokens = capacity
+			}
+			b.lastRefill = now
+		}
+	}
+
+	if b.tokens < 1 {
+		missing := 1 - b.tokens
+		wait := time.Duration(missing/refillPerSecond*float64(time.Second)) + time.Second
+		return false, wait
+	}
+	b.tokens--
+	return true, 0
+}
+
+// rateLimitMessage renders the localized "слишком часто" reply for a denied
+// action, rounding retryAfter to the nearest second (never below 1 second).
+func rateLimitMessage(retryAfter time.Duration) string {
+	secs := int(retryAfter.Round(time.Second) / time.Second)
+	if secs < 1 {
+		secs = 1
+	}
+	return fmt.Sprintf("Слишком часто. Попробуй через %d сек.", secs)
+}
+
+// userLimits holds per-user quota overrides, falling back to env-configured
+// defaults when a user has no row in user_limits.
+type userLimits struct {
+	MaxNotes      int
+	MaxTotalBytes int64
+}
+
+// The user_limits table itself is created by the migration runner (see
+// migrations.go). Rows are optional: a missing row means "use the defaults".
+
+// loadUserLimits returns userID's quota, falling back to defaults for any
+// column left NULL (or for users with no row at all).
+func loadUserLimits(ctx context.Context, db *sql.DB, userID int64, defaults userLimits) (userLimits, error) {
+	lim := defaults
+	var maxNotes, maxBytes sql.NullInt64
+	err := db.QueryRowContext(ctx,
+		`SELECT max_notes, max_total_bytes FROM user_limits WHERE user_id=?`, userID,
+	).Scan(&maxNotes, &maxBytes)
+	if err == sql.ErrNoRows {
+		return lim, nil
+	}
+	if err != nil {
+		return userLimits{}, err
+	}
+	if maxNotes.Valid {
+		lim.MaxNotes = int(maxNotes.Int64)
+	}
+	if maxBytes.Valid {
+		lim.MaxTotalBytes = maxBytes.Int64
+	}
+	return lim, nil
+}
+
+// noteUsage reports how many notes userID has and how many encrypted bytes
+// they occupy, for enforcing quotas before an insert. Archived revisions
+// (see editNote) count toward the byte total too, since they're stored
+// encrypted bytes like any other note payload — otherwise repeated edits
+// could grow note_revisions without bound under the same quota.
+func noteUsage(ctx context.Context, db *sql.DB, userID int64) (count int, totalBytes int64, err error) {
+	if err = db.QueryRowContext(ctx,
+		`SELECT COUNT(*), COALESCE(SUM(LENGTH(payload_enc)), 0) FROM notes WHERE user_id=?`, userID,
+	).Scan(&count, &totalBytes); err != nil {
+		return 0, 0, err
+	}
+	var revBytes int64
+	if err = db.QueryRowContext(ctx,
+		`SELECT COALESCE(SUM(LENGTH(nr.payload_enc)), 0) FROM note_revisions nr
+		 JOIN notes n ON n.id = nr.note_id WHERE n.user_id=?`, userID,
+	).Scan(&revBytes); err != nil {
+		return 0, 0, err
+	}
+	return count, totalBytes + revBytes, nil
+}
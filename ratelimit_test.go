@@ -0,0 +1,112 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// fakeClock lets tests move time forward deterministically instead of
+// depending on real wall-clock delays.
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time { return c.now }
+
+func (c *fakeClock) advance(d time.Duration) { c.now = c.now.Add(d) }
+
+func TestTokenBucketLimiter_AllowsBurstUpToCapacity(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(0, 0)}
+	l := NewTokenBucketLimiter(clock, map[string]int{"test": 5})
+
+	for i := 0; i < 5; i++ {
+		ok, _ := l.Allow(1, "test")
+		if !ok {
+			t.Fatalf("call %d: expected allow within burst capacity, got denied", i)
+		}
+	}
+
+	ok, retryAfter := l.Allow(1, "test")
+	if ok {
+		t.Fatal("expected the 6th call to be denied once the bucket is empty")
+	}
+	if retryAfter <= 0 {
+		t.Fatalf("expected a positive retryAfter, got %v", retryAfter)
+	}
+}
+
+func TestTokenBucketLimiter_RefillsOverTime(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(0, 0)}
+	l := NewTokenBucketLimiter(clock, map[string]int{"test": 60}) // 1 token/sec
+
+	for i := 0; i < 60; i++ {
+		if ok, _ := l.Allow(1, "test"); !ok {
+			t.Fatalf("call %d: expected allow within burst capacity", i)
+		}
+	}
+	if ok, _ := l.Allow(1, "test"); ok {
+		t.Fatal("expected denial once the bucket is drained")
+	}
+
+	clock.advance(2 * time.Second)
+	if ok, _ := l.Allow(1, "test"); !ok {
+		t.Fatal("expected allow after refilling for 2 seconds at 1 token/sec")
+	}
+}
+
+func TestTokenBucketLimiter_CapsRefillAtCapacity(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(0, 0)}
+	l := NewTokenBucketLimiter(clock, map[string]int{"test": 5})
+
+	if ok, _ := l.Allow(1, "test"); !ok {
+		t.Fatal("expected the first call to be allowed")
+	}
+
+	clock.advance(time.Hour) // far more than enough to refill fully
+	for i := 0; i < 5; i++ {
+		if ok, _ := l.Allow(1, "test"); !ok {
+			t.Fatalf("call %d: expected allow, bucket should have refilled to capacity (not beyond)", i)
+		}
+	}
+	if ok, _ := l.Allow(1, "test"); ok {
+		t.Fatal("expected denial: refill should cap at capacity, not accumulate unbounded")
+	}
+}
+
+func TestTokenBucketLimiter_PerUserPerActionIsolation(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(0, 0)}
+	l := NewTokenBucketLimiter(clock, map[string]int{"a": 1, "b": 1})
+
+	if ok, _ := l.Allow(1, "a"); !ok {
+		t.Fatal("expected user 1's first call on action a to be allowed")
+	}
+	if ok, _ := l.Allow(1, "a"); ok {
+		t.Fatal("expected user 1's second call on action a to be denied")
+	}
+	if ok, _ := l.Allow(1, "b"); !ok {
+		t.Fatal("expected user 1's action b bucket to be independent of action a")
+	}
+	if ok, _ := l.Allow(2, "a"); !ok {
+		t.Fatal("expected user 2's action a bucket to be independent of user 1's")
+	}
+}
+
+func TestTokenBucketLimiter_UnknownActionAlwaysAllowed(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(0, 0)}
+	l := NewTokenBucketLimiter(clock, map[string]int{"test": 1})
+
+	for i := 0; i < 3; i++ {
+		if ok, _ := l.Allow(1, "unconfigured"); !ok {
+			t.Fatalf("call %d: action with no configured limit should never be throttled", i)
+		}
+	}
+}
+
+func TestRateLimitMessage_RoundsToWholeSeconds(t *testing.T) {
+	if got := rateLimitMessage(200 * time.Millisecond); got != "Слишком часто. Попробуй через 1 сек." {
+		t.Fatalf("expected a sub-second wait to round up to at least 1 sec, got %q", got)
+	}
+	if got := rateLimitMessage(2300 * time.Millisecond); got != "Слишком часто. Попробуй через 2 сек." {
+		t.Fatalf("expected 2.3 sec to round to 2 sec, got %q", got)
+	}
+}
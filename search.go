@@ -0,0 +1,178 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// notes_index holds one row per distinct token found in a note's plaintext,
+// HMACed with the same key used to encrypt the note so the index reveals
+// nothing about note contents without the key. "#tag" and "@mention" tokens
+// are kept in their own kind so a search can be scoped to just tags or
+// mentions instead of matching plain words.
+const (
+	tokenKindWord    = "word"
+	tokenKindTag     = "tag"
+	tokenKindMention = "mention"
+)
+
+var (
+	reTag     = regexp.MustCompile(`#([\p{L}\p{N}_]+)`)
+	reMention = regexp.MustCompile(`@([\p{L}\p{N}_]+)`)
+	reWord    = regexp.MustCompile(`[\p{L}\p{N}]+`)
+)
+
+// The notes_index table itself is created by the migration runner (see
+// migrations.go).
+
+// execer is the subset of *sql.DB / *sql.Tx that indexNoteText needs, so it
+// can run inside saveNote's transaction or standalone (re-indexing).
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+// tokenizeForIndex splits text into lowercase words plus any #tag and
+// @mention tokens, unicode-aware. Tags and mentions are also matched by the
+// generic word regexp, so they're excluded from the word set to avoid
+// indexing "work" twice for "#work".
+func tokenizeForIndex(text string) (words, tags, mentions []string) {
+	lower := strings.ToLower(text)
+	for _, m := range reTag.FindAllStringSubmatch(lower, -1) {
+		tags = append(tags, m[1])
+	}
+	for _, m := range reMention.FindAllStringSubmatch(lower, -1) {
+		mentions = append(mentions, m[1])
+	}
+	masked := reTag.ReplaceAllString(lower, " ")
+	masked = reMention.ReplaceAllString(masked, " ")
+	words = reWord.FindAllString(masked, -1)
+	return words, tags, mentions
+}
+
+func hmacToken(key []byte, token string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(token))
+	return h.Sum(nil)
+}
+
+// indexNoteText tokenizes text and stores its HMACed tokens against noteID.
+// Safe to call with an empty text (e.g. a bare voice note): it's a no-op.
+func indexNoteText(ctx context.Context, ex execer, key []byte, noteID, userID int64, text string) error {
+	if strings.TrimSpace(text) == "" {
+		return nil
+	}
+	words, tags, mentions := tokenizeForIndex(text)
+
+	insert := func(kind string, tokens []string) error {
+		seen := map[string]bool{}
+		for _, t := range tokens {
+			if seen[t] {
+				continue
+			}
+			seen[t] = true
+			_, err := ex.ExecContext(ctx,
+				`INSERT INTO notes_index(note_id, user_id, kind, token) VALUES(?,?,?,?)`,
+				noteID, userID, kind, hmacToken(key, t),
+			)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	if err := insert(tokenKindWord, words); err != nil {
+		return err
+	}
+	if err := insert(tokenKindTag, tags); err != nil {
+		return err
+	}
+	return insert(tokenKindMention, mentions)
+}
+
+// searchNotes tokenizes query the same way notes are indexed, HMACs each
+// term, and AND-intersects the matching note IDs. It returns the id and
+// creation time of each non-deleted note that matched every term, newest
+// first; no note payload is decrypted here.
+func searchNotes(ctx context.Context, db *sql.DB, key []byte, userID int64, query string) ([]NoteRow, error) {
+	words, tags, mentions := tokenizeForIndex(query)
+	type term struct {
+		kind  string
+		token string
+	}
+	var terms []term
+	for _, w := range words {
+		terms = append(terms, term{tokenKindWord, w})
+	}
+	for _, t := range tags {
+		terms = append(terms, term{tokenKindTag, t})
+	}
+	for _, m := range mentions {
+		terms = append(terms, term{tokenKindMention, m})
+	}
+	if len(terms) == 0 {
+		return nil, nil
+	}
+
+	var matched map[int64]bool
+	for _, t := range terms {
+		rows, err := db.QueryContext(ctx,
+			`SELECT note_id FROM notes_index WHERE user_id=? AND kind=? AND token=?`,
+			userID, t.kind, hmacToken(key, t.token),
+		)
+		if err != nil {
+			return nil, err
+		}
+		ids := map[int64]bool{}
+		for rows.Next() {
+			var id int64
+			if err := rows.Scan(&id); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			ids[id] = true
+		}
+		if err := rows.Err(); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		rows.Close()
+
+		if matched == nil {
+			matched = ids
+		} else {
+			for id := range matched {
+				if !ids[id] {
+					delete(matched, id)
+				}
+			}
+		}
+		if len(matched) == 0 {
+			return nil, nil
+		}
+	}
+
+	out := make([]NoteRow, 0, len(matched))
+	for id := range matched {
+		var tStr string
+		err := db.QueryRowContext(ctx,
+			`SELECT created_at FROM notes WHERE id=? AND user_id=? AND deleted_at IS NULL`, id, userID,
+		).Scan(&tStr)
+		if err == sql.ErrNoRows {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		t, _ := time.Parse(time.RFC3339Nano, tStr)
+		out = append(out, NoteRow{ID: id, CreatedAt: t.Local()})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].CreatedAt.After(out[j].CreatedAt) })
+	return out, nil
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"log"
+	"time"
+)
+
+// StateStore persists per-user FSM state so that handleMessage and
+// handleCallback can share and resume it across process restarts. It also
+// gives multi-step flows (guided calculator sessions, password wizard, note
+// editing) a place to stash context between steps.
+type StateStore interface {
+	// Get returns the current state for userID, or a fresh ModeNone state
+	// if none is stored yet.
+	Get(ctx context.Context, userID int64) (*UserState, error)
+	Set(ctx context.Context, userID int64, st *UserState) error
+	Delete(ctx context.Context, userID int64) error
+	// SweepExpired resets any state whose Mode is not ModeNone and whose
+	// UpdatedAt is older than olderThan back to ModeNone, so an abandoned
+	// "awaiting input" flow doesn't wedge a user forever. It returns the
+	// number of states reset.
+	SweepExpired(ctx context.Context, olderThan time.Duration) (int64, error)
+}
+
+// UserState is the FSM state for a single user.
+type UserState struct {
+	Mode      Mode
+	Context   map[string]string
+	UpdatedAt time.Time
+}
+
+// sqliteStateStore implements StateStore on top of the user_states table.
+type sqliteStateStore struct {
+	db *sql.DB
+}
+
+// NewSQLiteStateStore returns a StateStore backed by db. The user_states
+// table itself is created by the migration runner (see migrations.go);
+// callers must run migrations before using the returned store.
+func NewSQLiteStateStore(db *sql.DB) *sqliteStateStore {
+	return &sqliteStateStore{db: db}
+}
+
+func (s *sqliteStateStore) Get(ctx context.Context, userID int64) (*UserState, error) {
+	var mode Mode
+	var ctxJSON string
+	var updatedAt string
+	err := s.db.QueryRowContext(ctx,
+		`SELECT mode, context_json, updated_at FROM user_states WHERE user_id=?`,
+		userID,
+	).Scan(&mode, &ctxJSON, &updatedAt)
+	if err == sql.ErrNoRows {
+		return &UserState{Mode: ModeNone, Context: map[string]string{}}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var c map[string]string
+	if err := json.Unmarshal([]byte(ctxJSON), &c); err != nil {
+		return nil, err
+	}
+	t, _ := time.Parse(time.RFC3339Nano, updatedAt)
+	return &UserState{Mode: mode, Context: c, UpdatedAt: t.Local()}, nil
+}
+
+func (s *sqliteStateStore) Set(ctx context.Context, userID int64, st *UserState) error {
+	if st.Context == nil {
+		st.Context = map[string]string{}
+	}
+	ctxJSON, err := json.Marshal(st.Context)
+	if err != nil {
+		return err
+	}
+	now := time.Now().UTC().Format(time.RFC3339Nano)
+	_, err = s.db.ExecContext(ctx, `
+INSERT INTO user_states(user_id, mode, context_json, updated_at) VALUES(?,?,?,?)
+ON CONFLICT(user_id) DO UPDATE SET mode=excluded.mode, context_json=excluded.context_json, updated_at=excluded.updated_at
+`, userID, st.Mode, string(ctxJSON), now)
+	return err
+}
+
+func (s *sqliteStateStore) Delete(ctx context.Context, userID int64) error {
+	_, err := s.db.ExecContext(ctx, `DELETE FROM user_states WHERE user_id=?`, userID)
+	return err
+}
+
+func (s *sqliteStateStore) SweepExpired(ctx context.Context, olderThan time.Duration) (int64, error) {
+	cutoff := time.Now().UTC().Add(-olderThan).Format(time.RFC3339Nano)
+	now := time.Now().UTC().Format(time.RFC3339Nano)
+	res, err := s.db.ExecContext(ctx, `
+UPDATE user_states SET mode=?, context_json='{}', updated_at=?
+WHERE mode<>? AND updated_at<?
+`, ModeNone, now, ModeNone, cutoff)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// runStateSweeper periodically expires stale awaiting-input states so a
+// user who abandons a flow (e.g. leaves calculator mid-expression) doesn't
+// stay stuck in it. It blocks until ctx is cancelled.
+func runStateSweeper(ctx context.Context, store StateStore, ttl time.Duration) {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if n, err := store.SweepExpired(ctx, ttl); err != nil {
+				log.Printf("state sweeper: %v", err)
+			} else if n > 0 {
+				log.Printf("state sweeper: expired %d stale state(s)", n)
+			}
+		}
+	}
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// Transcriber turns voice-note audio into text. Implementations take the
+// note's directly downloadable URL (as returned by bot.GetFileDirectURL)
+// rather than raw bytes, since that's what Telegram gives us.
+type Transcriber interface {
+	Transcribe(ctx context.Context, audioURL, mimeType string) (string, error)
+}
+
+// transcribeTimeout bounds both the HTTP client used to talk to the
+// transcription backend and the background context each transcription
+// runs under, so a slow or unreachable backend can't hang around forever.
+const transcribeTimeout = 20 * time.Second
+
+// newTranscriberFromEnv builds the configured Transcriber, or nil if
+// transcription is disabled (TRANSCRIBE_ENABLED != "1"). TRANSCRIBE_URL
+// selects the backend: an URL ending in "/inference" is treated as a
+// whisper.cpp HTTP server, anything else as an OpenAI-compatible
+// /audio/transcriptions endpoint.
+func newTranscriberFromEnv() Transcriber {
+	if getenvDefault("TRANSCRIBE_ENABLED", "") != "1" {
+		return nil
+	}
+	url := mustEnv("TRANSCRIBE_URL")
+	client := &http.Client{Timeout: transcribeTimeout}
+	if strings.HasSuffix(url, "/inference") {
+		return &whisperCppTranscriber{url: url, client: client}
+	}
+	return &openAICompatTranscriber{
+		url:    url,
+		model:  getenvDefault("TRANSCRIBE_MODEL", "whisper-1"),
+		apiKey: getenvDefault("TRANSCRIBE_API_KEY", ""),
+		client: client,
+	}
+}
+
+// transcribeVoiceAsync transcribes a just-saved voice note in the
+// background and attaches the result once it's ready. handleMessage runs
+// updates one at a time, so doing this inline would stall every other
+// user behind a slow or unreachable transcription backend; running it in
+// its own goroutine with a bounded context keeps the note saving path
+// fast regardless. With no transcriber configured it does nothing. Other
+// failures (can't resolve the file URL, the backend errors out) are logged
+// and otherwise ignored — the note already saved without a transcript.
+func (a *App) transcribeVoiceAsync(chatID, userID, noteID int64) {
+	if a.transcriber == nil {
+		return
+	}
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), transcribeTimeout)
+		defer cancel()
+
+		payload, _, err := loadNote(ctx, a.db, a.key, userID, noteID)
+		if err != nil {
+			log.Printf("transcribe: load note: %v", err)
+			return
+		}
+		url, err := a.bot.GetFileDirectURL(payload.FileID)
+		if err != nil {
+			log.Printf("transcribe: resolve file url: %v", err)
+			return
+		}
+		text, err := a.transcriber.Transcribe(ctx, url, payload.MimeType)
+		if err != nil {
+			log.Printf("transcribe: %v", err)
+			return
+		}
+		if err := attachTranscript(ctx, a.db, a.key, userID, noteID, text); err != nil {
+			log.Printf("transcribe: attach: %v", err)
+			return
+		}
+		_, _ = a.bot.Send(tgbotapi.NewMessage(chatID, "📝 Расшифровка:\n"+text))
+	}()
+}
+
+// downloadAudio fetches audioURL's bytes so they can be attached to a
+// multipart transcription request.
+func downloadAudio(ctx context.Context, client *http.Client, audioURL string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, audioURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("download audio: status %d", resp.StatusCode)
+	}
+	return io.ReadAll(resp.Body)
+}
+
+// buildTranscriptionRequest wraps audio bytes in a multipart/form-data body
+// under a "file" field, plus whatever extra fields the caller needs.
+func buildTranscriptionRequest(ctx context.Context, url string, audio []byte, extraFields map[string]string) (*http.Request, error) {
+	var buf bytes.Buffer
+	w := multipart.NewWriter(&buf)
+	part, err := w.CreateFormFile("file", "voice.ogg")
+	if err != nil {
+		return nil, err
+	}
+	if _, err := part.Write(audio); err != nil {
+		return nil, err
+	}
+	for k, v := range extraFields {
+		if err := w.WriteField(k, v); err != nil {
+			return nil, err
+		}
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, &buf)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", w.FormDataContentType())
+	return req, nil
+}
+
+// transcriptionResponse is the common shape both backends reply with.
+type transcriptionResponse struct {
+	Text string `json:"text"`
+}
+
+// whisperCppTranscriber calls a local whisper.cpp server's /inference
+// endpoint.
+type whisperCppTranscriber struct {
+	url    string
+	client *http.Client
+}
+
+func (t *whisperCppTranscriber) Transcribe(ctx context.Context, audioURL, mimeType string) (string, error) {
+	audio, err := downloadAudio(ctx, t.client, audioURL)
+	if err != nil {
+		return "", err
+	}
+	req, err := buildTranscriptionRequest(ctx, t.url, audio, nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("whisper.cpp: status %d", resp.StatusCode)
+	}
+	var out transcriptionResponse
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(out.Text), nil
+}
+
+// openAICompatTranscriber calls an OpenAI-compatible /audio/transcriptions
+// endpoint (OpenAI itself, or a self-hosted server speaking the same API).
+type openAICompatTranscriber struct {
+	url    string
+	model  string
+	apiKey string
+	client *http.Client
+}
+
+func (t *openAICompatTranscriber) Transcribe(ctx context.Context, audioURL, mimeType string) (string, error) {
+	audio, err := downloadAudio(ctx, t.client, audioURL)
+	if err != nil {
+		return "", err
+	}
+	req, err := buildTranscriptionRequest(ctx, t.url, audio, map[string]string{"model": t.model})
+	if err != nil {
+		return "", err
+	}
+	if t.apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+t.apiKey)
+	}
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("transcription endpoint: status %d", resp.StatusCode)
+	}
+	var out transcriptionResponse
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(out.Text), nil
+}